@@ -0,0 +1,385 @@
+package emos
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// binaryMagic starts binary catalogs, the digit is the format version
+const binaryMagic = "EMOSBIN1"
+
+// binaryHeaderSize is the magic, the generation and the number of emojis
+const binaryHeaderSize = len(binaryMagic) + 8 + 4
+
+var errCorruptBinary = errors.New("corrupt binary catalog")
+
+// binaryLoc is where the binary catalog for the cache at cacheLoc is kept,
+// e.g. emoji.bin next to emoji.json
+func binaryLoc(cacheLoc string) string {
+	return strings.TrimSuffix(cacheLoc, filepath.Ext(cacheLoc)) + ".bin"
+}
+
+// binaryCatalog is a memory mapped catalog which only decodes the emojis
+// that are looked up. After the header follows a table with the offset of
+// every record, ordered by id, and then the records. A record is the
+// length prefixed id followed by the length prefixed encoded emoji.
+type binaryCatalog struct {
+	data       []byte
+	generation int64
+	count      int
+	unmap      func() error
+}
+
+func writeBinaryCatalog(loc string, c *cacheFile) error {
+	ids := make([]string, 0, len(c.Emojis))
+	for id := range c.Emojis {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	var records bytes.Buffer
+	offsets := make([]uint32, len(ids))
+	tableEnd := binaryHeaderSize + 4*len(ids)
+	var payload emojiEncoder
+	for i, id := range ids {
+		offsets[i] = uint32(tableEnd + records.Len())
+		payload.reset()
+		payload.emoji(c.Emojis[id])
+
+		var rec emojiEncoder
+		rec.string(id)
+		rec.bytes(payload.buf)
+		records.Write(rec.buf)
+	}
+
+	var out bytes.Buffer
+	out.Grow(tableEnd + records.Len())
+	out.WriteString(binaryMagic)
+	binary.Write(&out, binary.LittleEndian, c.Generation)
+	binary.Write(&out, binary.LittleEndian, uint32(len(ids)))
+	binary.Write(&out, binary.LittleEndian, offsets)
+	out.Write(records.Bytes())
+
+	dir := filepath.Dir(loc)
+	f, err := ioutil.TempFile(dir, filepath.Base(loc)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for binary catalog: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(out.Bytes())
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), writePerms)
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), loc)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write binary catalog: %w", err)
+	}
+	syncDir(dir)
+	return nil
+}
+
+func openBinaryCatalog(loc string) (*binaryCatalog, error) {
+	f, err := os.Open(loc)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() < int64(binaryHeaderSize) {
+		return nil, fmt.Errorf("%w: too short", errCorruptBinary)
+	}
+
+	data, unmap, err := mmapFile(f, int(info.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to map binary catalog: %w", err)
+	}
+	b := &binaryCatalog{data: data, unmap: unmap}
+
+	if string(data[:len(binaryMagic)]) != binaryMagic {
+		b.Close()
+		return nil, fmt.Errorf("%w: unknown format", errCorruptBinary)
+	}
+	b.generation = int64(binary.LittleEndian.Uint64(data[len(binaryMagic):]))
+	b.count = int(binary.LittleEndian.Uint32(data[len(binaryMagic)+8:]))
+	if binaryHeaderSize+4*b.count > len(data) {
+		b.Close()
+		return nil, fmt.Errorf("%w: offset table is cut off", errCorruptBinary)
+	}
+	return b, nil
+}
+
+// Close unmaps the catalog, emojis which were decoded stay valid
+func (b *binaryCatalog) Close() error {
+	if b.unmap == nil {
+		return nil
+	}
+	err := b.unmap()
+	b.data, b.unmap = nil, nil
+	return err
+}
+
+// record returns the id and encoded emoji of the i-th record
+func (b *binaryCatalog) record(i int) (id, payload []byte, err error) {
+	off := int(binary.LittleEndian.Uint32(b.data[binaryHeaderSize+4*i:]))
+	if off > len(b.data) {
+		return nil, nil, fmt.Errorf("%w: record %d is out of bounds", errCorruptBinary, i)
+	}
+
+	d := emojiDecoder{buf: b.data[off:]}
+	id = d.bytes()
+	payload = d.bytes()
+	if d.err != nil {
+		return nil, nil, fmt.Errorf("%w: record %d: %v", errCorruptBinary, i, d.err)
+	}
+	return id, payload, nil
+}
+
+// Get decodes the emoji with the id, ok is false when there is none
+func (b *binaryCatalog) Get(id string) (e *Emoji, ok bool, err error) {
+	i := sort.Search(b.count, func(i int) bool {
+		recID, _, recErr := b.record(i)
+		if recErr != nil {
+			err = recErr
+			return true
+		}
+		return string(recID) >= id
+	})
+	if err != nil || i == b.count {
+		return nil, false, err
+	}
+
+	recID, payload, err := b.record(i)
+	if err != nil || string(recID) != id {
+		return nil, false, err
+	}
+	e, err = decodeEmoji(payload)
+	return e, err == nil, err
+}
+
+// IDs lists the ids of every emoji without decoding them
+func (b *binaryCatalog) IDs() ([]string, error) {
+	ids := make([]string, 0, b.count)
+	for i := 0; i < b.count; i++ {
+		id, _, err := b.record(i)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, string(id))
+	}
+	return ids, nil
+}
+
+// All decodes every emoji of the catalog
+func (b *binaryCatalog) All() (map[string]*Emoji, error) {
+	emojis := make(map[string]*Emoji, b.count)
+	for i := 0; i < b.count; i++ {
+		id, payload, err := b.record(i)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeEmoji(payload)
+		if err != nil {
+			return nil, err
+		}
+		emojis[string(id)] = e
+	}
+	return emojis, nil
+}
+
+// emojiEncoder appends the fields of emojis as varints and length
+// prefixed strings
+type emojiEncoder struct {
+	buf []byte
+	tmp [binary.MaxVarintLen64]byte
+}
+
+func (enc *emojiEncoder) reset() {
+	enc.buf = enc.buf[:0]
+}
+
+func (enc *emojiEncoder) uint(n uint64) {
+	enc.buf = append(enc.buf, enc.tmp[:binary.PutUvarint(enc.tmp[:], n)]...)
+}
+
+func (enc *emojiEncoder) int(n int) {
+	enc.buf = append(enc.buf, enc.tmp[:binary.PutVarint(enc.tmp[:], int64(n))]...)
+}
+
+func (enc *emojiEncoder) bytes(b []byte) {
+	enc.uint(uint64(len(b)))
+	enc.buf = append(enc.buf, b...)
+}
+
+func (enc *emojiEncoder) string(s string) {
+	enc.uint(uint64(len(s)))
+	enc.buf = append(enc.buf, s...)
+}
+
+func (enc *emojiEncoder) strings(ss []string) {
+	enc.uint(uint64(len(ss)))
+	for _, s := range ss {
+		enc.string(s)
+	}
+}
+
+func (enc *emojiEncoder) bool(b bool) {
+	if b {
+		enc.buf = append(enc.buf, 1)
+	} else {
+		enc.buf = append(enc.buf, 0)
+	}
+}
+
+// emoji writes the fields in the order of the Emoji struct, decodeEmoji
+// has to read them in the same order
+func (enc *emojiEncoder) emoji(e *Emoji) {
+	enc.string(e.Title)
+	enc.string(e.Image)
+	enc.string(e.Description)
+	enc.string(e.Category)
+	enc.int(e.Width)
+	enc.int(e.Height)
+	enc.string(e.Character)
+	enc.strings(e.Keywords)
+	enc.strings(e.Aliases)
+	enc.string(e.DiscordID)
+	enc.bool(e.Animated)
+	enc.string(e.Slug)
+	enc.string(e.License)
+	enc.int(e.Faves)
+	enc.string(e.Author)
+	enc.int(e.FileSize)
+	enc.string(e.Source)
+	enc.strings(e.Variants)
+	enc.string(e.VariantOf)
+}
+
+// emojiDecoder reads what emojiEncoder wrote, the first error is kept and
+// stops further reads
+type emojiDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *emojiDecoder) uint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Uvarint(d.buf)
+	if size <= 0 {
+		d.err = errors.New("invalid length")
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return n
+}
+
+func (d *emojiDecoder) int() int {
+	if d.err != nil {
+		return 0
+	}
+	n, size := binary.Varint(d.buf)
+	if size <= 0 {
+		d.err = errors.New("invalid number")
+		return 0
+	}
+	d.buf = d.buf[size:]
+	return int(n)
+}
+
+func (d *emojiDecoder) bytes() []byte {
+	n := d.uint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("value is cut off")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// string copies the bytes, so it stays valid when the catalog is unmapped
+func (d *emojiDecoder) string() string {
+	return string(d.bytes())
+}
+
+func (d *emojiDecoder) strings() []string {
+	n := d.uint()
+	if d.err != nil || n == 0 {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = errors.New("list is cut off")
+		return nil
+	}
+	ss := make([]string, n)
+	for i := range ss {
+		ss[i] = d.string()
+	}
+	return ss
+}
+
+func (d *emojiDecoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if len(d.buf) == 0 {
+		d.err = errors.New("value is cut off")
+		return false
+	}
+	b := d.buf[0]
+	d.buf = d.buf[1:]
+	return b == 1
+}
+
+func decodeEmoji(payload []byte) (*Emoji, error) {
+	d := emojiDecoder{buf: payload}
+	e := &Emoji{
+		Title:       d.string(),
+		Image:       d.string(),
+		Description: d.string(),
+		Category:    d.string(),
+		Width:       d.int(),
+		Height:      d.int(),
+		Character:   d.string(),
+		Keywords:    d.strings(),
+		Aliases:     d.strings(),
+		DiscordID:   d.string(),
+		Animated:    d.bool(),
+		Slug:        d.string(),
+		License:     d.string(),
+		Faves:       d.int(),
+		Author:      d.string(),
+		FileSize:    d.int(),
+		Source:      d.string(),
+		Variants:    d.strings(),
+		VariantOf:   d.string(),
+	}
+	if d.err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptBinary, d.err)
+	}
+	return e, nil
+}
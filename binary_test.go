@@ -0,0 +1,120 @@
+package emos
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testCatalog(n int) *cacheFile {
+	emojis := make(map[string]*Emoji, n)
+	for i := 0; i < n; i++ {
+		emojis[fmt.Sprintf("emojigg:%d", i)] = &Emoji{
+			Title:       fmt.Sprintf("pepe%d", i),
+			Image:       fmt.Sprintf("https://emoji.gg/assets/emoji/%d-pepe.png", i),
+			Description: "a frog which is sad sometimes",
+			Category:    "Pepe",
+			Width:       64,
+			Height:      64,
+			Keywords:    []string{"frog", "sad"},
+			Slug:        fmt.Sprintf("%d-pepe", i),
+			License:     "0",
+			Faves:       i % 100,
+			Author:      "voldy",
+			FileSize:    2048,
+			Source:      "emojigg",
+		}
+	}
+	emojis["unicode:U+2764-FE0F"] = &Emoji{Title: "red heart", Character: "❤️", Animated: true, Source: "unicode"}
+	return newCache(nil, emojis, 7)
+}
+
+func TestBinaryCatalog(t *testing.T) {
+	c := testCatalog(100)
+	loc := filepath.Join(t.TempDir(), "emoji.bin")
+	if err := writeBinaryCatalog(loc, c); err != nil {
+		t.Fatal("unable to write binary catalog:", err)
+	}
+
+	b, err := openBinaryCatalog(loc)
+	if err != nil {
+		t.Fatal("unable to open binary catalog:", err)
+	}
+	defer b.Close()
+
+	if b.generation != 7 || b.count != len(c.Emojis) {
+		t.Fatalf("unexpected header, generation %d and %d emojis", b.generation, b.count)
+	}
+	for _, id := range []string{"emojigg:0", "emojigg:42", "unicode:U+2764-FE0F"} {
+		e, ok, err := b.Get(id)
+		if err != nil || !ok {
+			t.Fatalf("expected to find %s, got: %v", id, err)
+		}
+		if !reflect.DeepEqual(e, c.Emojis[id]) {
+			t.Fatalf("%s decoded as %+v, expected %+v", id, e, c.Emojis[id])
+		}
+	}
+	if _, ok, err := b.Get("emojigg:100"); ok || err != nil {
+		t.Fatalf("expected no emoji for an unknown id, got: %v", err)
+	}
+
+	all, err := b.All()
+	if err != nil || !reflect.DeepEqual(all, c.Emojis) {
+		t.Fatalf("expected every emoji to be decoded, got: %v", err)
+	}
+}
+
+// the cold start benchmarks open the catalog and look up the first page
+// of results, like every emos invocation does
+func benchmarkColdStart(b *testing.B, write func(loc string, c *cacheFile) error, lookup func(loc string, ids []string) error) {
+	c := testCatalog(20000)
+	loc := filepath.Join(b.TempDir(), "emoji")
+	if err := write(loc, c); err != nil {
+		b.Fatal(err)
+	}
+	ids := make([]string, 20)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("emojigg:%d", i*997)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := lookup(loc, ids); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkColdStartJSON(b *testing.B) {
+	benchmarkColdStart(b, func(loc string, c *cacheFile) error {
+		return writeCache(loc, c, NoCompression)
+	}, func(loc string, ids []string) error {
+		c, _, err := readCache(loc)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, ok := c.Emojis[id]; !ok {
+				return fmt.Errorf("%s not found", id)
+			}
+		}
+		return nil
+	})
+}
+
+func BenchmarkColdStartBinary(b *testing.B) {
+	benchmarkColdStart(b, writeBinaryCatalog, func(loc string, ids []string) error {
+		cat, err := openBinaryCatalog(loc)
+		if err != nil {
+			return err
+		}
+		defer cat.Close()
+		for _, id := range ids {
+			if _, ok, err := cat.Get(id); !ok {
+				return fmt.Errorf("%s not found: %v", id, err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,229 @@
+package emos
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cacheVersion is the version of the cache format written by this package.
+// Version 1 was a bare map of emojis without a header.
+const cacheVersion = 2
+
+// errCorruptCache is wrapped by errors of caches which can't be decoded
+var errCorruptCache = errors.New("corrupt emoji cache")
+
+// cacheHeader describes the catalog in a cache file
+type cacheHeader struct {
+	// Version of the cache format
+	Version int
+	// Sources are the id prefixes of the providers of the catalog
+	Sources []string
+	// Providers describe the providers of the catalog, so it can be
+	// updated with the same ones, see WithCachedSetup
+	Providers []providerSetup `json:",omitempty"`
+	// Compression the cache was written with
+	Compression Compression `json:",omitempty"`
+	// FetchedAt is when the catalog was downloaded, zero when unknown
+	FetchedAt time.Time
+	// Count is the number of emojis, a different count means the cache
+	// was cut off
+	Count int
+	// Generation increases with every update, the index records the
+	// generation it was built from to detect when it doesn't match
+	Generation int64 `json:",omitempty"`
+}
+
+type cacheFile struct {
+	cacheHeader
+	Emojis map[string]*Emoji
+}
+
+func newCache(providers []Provider, emojis map[string]*Emoji, generation int64) *cacheFile {
+	sources := make([]string, 0, len(providers))
+	for _, p := range providers {
+		sources = append(sources, p.IDPrefix())
+	}
+
+	return &cacheFile{
+		cacheHeader: cacheHeader{
+			Version:    cacheVersion,
+			Sources:    sources,
+			Providers:  providerSetups(providers),
+			FetchedAt:  time.Now().UTC(),
+			Count:      len(emojis),
+			Generation: generation,
+		},
+		Emojis: emojis,
+	}
+}
+
+// readCache reads the cache at cacheLoc, caches of older versions are
+// migrated to the current one and migrated is true then
+func readCache(cacheLoc string) (c *cacheFile, migrated bool, err error) {
+	data, err := ioutil.ReadFile(cacheLoc)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read cache: %w", err)
+	}
+	if data, err = decompress(data); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorruptCache, err)
+	}
+
+	var header cacheHeader
+	if err := json.Unmarshal(data, &header); err != nil {
+		return nil, false, fmt.Errorf("%w: %v", errCorruptCache, err)
+	}
+
+	switch {
+	case header.Version == 0:
+		c, err = migrateV1Cache(data)
+		migrated = true
+	case header.Version > cacheVersion:
+		err = fmt.Errorf("%w: version %d is newer than the supported version %d", errCorruptCache, header.Version, cacheVersion)
+	default:
+		c = &cacheFile{}
+		if err = json.Unmarshal(data, c); err != nil {
+			err = fmt.Errorf("%w: %v", errCorruptCache, err)
+		}
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	if c.Count != len(c.Emojis) {
+		return nil, false, fmt.Errorf("%w: expected %d emojis but found %d", errCorruptCache, c.Count, len(c.Emojis))
+	}
+	return c, migrated, nil
+}
+
+// migrateV1Cache reads a bare map of emojis, the ids of caches from before
+// multiple providers were supported are namespaced as emoji.gg ids
+func migrateV1Cache(data []byte) (*cacheFile, error) {
+	emojis := map[string]*Emoji{}
+	if err := json.Unmarshal(data, &emojis); err != nil {
+		return nil, fmt.Errorf("%w: %v", errCorruptCache, err)
+	}
+
+	sources := map[string]bool{}
+	for id, e := range emojis {
+		if !strings.Contains(id, ":") {
+			delete(emojis, id)
+			e.Source = (&EmojiGG{}).IDPrefix()
+			emojis[namespacedID(e.Source, id)] = e
+		}
+		sources[e.Source] = true
+	}
+
+	c := &cacheFile{
+		cacheHeader: cacheHeader{Version: cacheVersion, Count: len(emojis)},
+		Emojis:      emojis,
+	}
+	for source := range sources {
+		c.Sources = append(c.Sources, source)
+	}
+	sort.Strings(c.Sources)
+	return c, nil
+}
+
+// BackupLoc is where the previous cache is kept when the cache at cacheLoc
+// is replaced by an update which changed it
+func BackupLoc(cacheLoc string) string {
+	return cacheLoc + ".bak"
+}
+
+// ReadCatalog reads the emojis of a cache file of any version, keyed by
+// their namespaced ids
+func ReadCatalog(cacheLoc string) (map[string]*Emoji, error) {
+	c, _, err := readCache(cacheLoc)
+	if err != nil {
+		return nil, err
+	}
+	return c.Emojis, nil
+}
+
+// writeCache replaces the cache at cacheLoc without ever leaving a partly
+// written cache behind. The cache is written to a temporary file in the
+// same directory, since renames don't work across filesystems, and the
+// previous cache is kept as a backup.
+func writeCache(cacheLoc string, c *cacheFile, compression Compression) error {
+	dir := filepath.Dir(cacheLoc)
+	f, err := ioutil.TempFile(dir, filepath.Base(cacheLoc)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("unable to create temp file for cache: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	c.Compression = compression
+	w, err := compressWriter(f, compression)
+	if err == nil {
+		err = json.NewEncoder(w).Encode(c)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), writePerms)
+	}
+	if err != nil {
+		return fmt.Errorf("unable to write cache: %w", err)
+	}
+
+	if err := os.Rename(cacheLoc, BackupLoc(cacheLoc)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to back up cache: %w", err)
+	}
+	if err := os.Rename(f.Name(), cacheLoc); err != nil {
+		return fmt.Errorf("unable to replace cache: %w", err)
+	}
+	syncDir(dir)
+
+	// the binary catalog is only used while it has the same generation
+	// as the index, failing to write it only makes starting slower
+	if err := writeBinaryCatalog(binaryLoc(cacheLoc), c); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to write binary catalog, ignoring:", err)
+	}
+	return nil
+}
+
+// syncDir makes renames in dir durable, it is best effort as not every
+// platform can sync directories
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
+
+// restoreBackup moves the backup of the cache back in place, which is left
+// when replacing the cache was interrupted or the cache is unreadable
+func restoreBackup(cacheLoc string) (*cacheFile, bool) {
+	bak := BackupLoc(cacheLoc)
+	c, _, err := readCache(bak)
+	if err != nil {
+		return nil, false
+	}
+	if err := os.Rename(bak, cacheLoc); err != nil {
+		return nil, false
+	}
+	return c, true
+}
+
+// quarantineCache moves an unreadable cache aside, so it can be inspected
+// instead of being overwritten
+func quarantineCache(cacheLoc string) (string, error) {
+	dest := fmt.Sprintf("%s.corrupt-%d", cacheLoc, time.Now().Unix())
+	return dest, os.Rename(cacheLoc, dest)
+}
@@ -0,0 +1,102 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadCacheMigratesV1(t *testing.T) {
+	loc := filepath.Join(t.TempDir(), "emoji.json")
+	v1 := `{"5":{"Title":"pepehug"},"unicode:U+1F622":{"Title":"crying face","Source":"unicode"}}`
+	if err := ioutil.WriteFile(loc, []byte(v1), writePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	c, migrated, err := readCache(loc)
+	if err != nil {
+		t.Fatal("unable to read v1 cache:", err)
+	}
+	if !migrated || c.Version != cacheVersion || c.Count != 2 {
+		t.Fatalf("expected a migrated cache, got: %+v", c.cacheHeader)
+	}
+	if e := c.Emojis["emojigg:5"]; e == nil || e.Source != "emojigg" {
+		t.Fatalf("expected the legacy id to be namespaced, got: %+v", c.Emojis)
+	}
+	if len(c.Sources) != 2 || c.Sources[0] != "emojigg" || c.Sources[1] != "unicode" {
+		t.Fatalf("unexpected sources: %v", c.Sources)
+	}
+}
+
+func TestGetEmojisQuarantinesCorruptCache(t *testing.T) {
+	dir := t.TempDir()
+	loc := filepath.Join(dir, "emoji.json")
+	if err := ioutil.WriteFile(loc, []byte(`{"Version":2,"Count":3,"Emojis":{}}`), writePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := &EmojiGG{BaseURL: "http://127.0.0.1:0/", Retries: -1}
+	es := &EmojiSearch{emojiCacheLoc: loc, providers: []Provider{failing}}
+	_, err := es.getEmojis(context.Background())
+	if err == nil || errors.Is(err, errCorruptCache) {
+		t.Fatalf("expected fetching to fail, got: %v", err)
+	}
+
+	quarantined, _ := filepath.Glob(loc + ".corrupt-*")
+	if len(quarantined) != 1 {
+		t.Fatalf("expected the cache to be moved aside, found: %v", quarantined)
+	}
+	if _, err := os.Stat(loc); !os.IsNotExist(err) {
+		t.Fatalf("expected no cache to be left, got: %v", err)
+	}
+}
+
+func TestWriteCacheKeepsBackup(t *testing.T) {
+	loc := filepath.Join(t.TempDir(), "emoji.json")
+	emojis := map[string]*Emoji{"unicode:U+1F622": {Title: "crying face"}}
+
+	for gen := int64(1); gen <= 2; gen++ {
+		if err := writeCache(loc, newCache(nil, emojis, gen), NoCompression); err != nil {
+			t.Fatal("unable to write cache:", err)
+		}
+	}
+
+	// an update interrupted between moving the cache and its replacement
+	if err := os.Remove(loc); err != nil {
+		t.Fatal(err)
+	}
+	c, ok := restoreBackup(loc)
+	if !ok || c.Generation != 1 {
+		t.Fatalf("expected the backup of generation 1, got: %+v", c)
+	}
+	if _, err := os.Stat(loc); err != nil {
+		t.Fatal("expected the backup to be moved in place:", err)
+	}
+}
+
+func TestWriteCacheCompressed(t *testing.T) {
+	emojis := map[string]*Emoji{"unicode:U+1F622": {Title: "crying face"}}
+
+	for _, name := range []string{"none", "gzip", "zstd"} {
+		compression, err := ParseCompression(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		loc := filepath.Join(t.TempDir(), "emoji.json")
+		if err := writeCache(loc, newCache(nil, emojis, 1), compression); err != nil {
+			t.Fatalf("unable to write %s cache: %v", name, err)
+		}
+
+		c, _, err := readCache(loc)
+		if err != nil {
+			t.Fatalf("unable to read %s cache: %v", name, err)
+		}
+		if e := c.Emojis["unicode:U+1F622"]; e == nil || e.Title != "crying face" {
+			t.Fatalf("unexpected emojis in %s cache: %+v", name, c.Emojis)
+		}
+	}
+}
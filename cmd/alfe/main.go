@@ -2,18 +2,24 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/voldyman/emos"
+	"github.com/voldyman/emos/internal/background"
 	"github.com/voldyman/emos/internal/config"
+	"github.com/voldyman/emos/internal/webclient"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -22,19 +28,37 @@ const (
 )
 
 var (
-	searchFlag = flag.Bool("search", false, "searches an emoji")
-	updateFlag = flag.Bool("update", false, "updates the emoji database")
+	searchFlag    = flag.Bool("search", false, "searches an emoji")
+	updateFlag    = flag.Bool("update", false, "updates the emoji database")
+	apiFlag       = flag.String("api", emos.DefaultEmojiGGURL, "emoji.gg api url")
+	timeoutFlag   = flag.Duration("timeout", 30*time.Second, "timeout for each http request")
+	userAgentFlag = flag.String("user-agent", webclient.DefaultUserAgent, "user agent for http requests")
+	caCertFlag    = flag.String("ca-cert", "", "pem file with additional certificate authorities to trust")
+	ttlFlag       = flag.Duration("ttl", 24*time.Hour, "refresh emojis older than this in the background, 0 never does")
 )
 
+var httpClient = http.DefaultClient
+
 func main() {
 	flag.Parse()
+
+	if *caCertFlag != "" {
+		client, err := newHTTPClient(*caCertFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+			os.Exit(1)
+		}
+		httpClient = client
+	}
+
 	err := fmt.Errorf("no command specified")
 
 	if *updateFlag {
 		err = runUpdate()
 	}
 
-	if updatedNeeded() {
+	// an update which failed shouldn't keep starting new ones
+	if !*updateFlag && updatedNeeded() {
 		forkUpdate()
 	}
 
@@ -49,42 +73,81 @@ func main() {
 }
 
 func runUpdate() error {
-	emos, err := newEmos()
+	es, err := newEmos()
 	if err != nil {
 		return fmt.Errorf("failed to start emoji search: %w", err)
 	}
-	defer emos.Close()
-
-	if err = emos.UpdateEmojis(); err != nil {
+	defer es.Close()
+
+	var partial *emos.PartialError
+	if err = es.UpdateEmojis(); errors.As(err, &partial) {
+		fmt.Fprintln(os.Stderr, "some emojis could not be updated:", err)
+	} else if errors.Is(err, emos.ErrUpdateInProgress) {
+		fmt.Fprintln(os.Stderr, "emojis are already being updated")
+		return nil
+	} else if err != nil {
 		return fmt.Errorf("failed to update emojis: %w", err)
 	}
-	fmt.Fprintln(os.Stderr, "updated local emojis")
-
-	emos.RefreshIndex()
-	fmt.Fprintln(os.Stderr, "updated emoji index")
+	fmt.Fprintln(os.Stderr, "updated local emojis and index")
 
 	return nil
 }
 
 func updatedNeeded() bool {
-	emos, err := newEmos()
-	defer emos.Close()
+	es, err := newEmos()
 	if err != nil {
 		return true
 	}
-	return emos.IsIndexEmpty()
+	defer es.Close()
+	if es.UpdateInProgress() {
+		return false
+	}
+	return es.IsIndexEmpty() || es.NeedsRefresh()
 }
 
+// forkUpdate refreshes the emojis in a detached process, results are
+// served from the current emojis meanwhile
 func forkUpdate() {
-	cmd := exec.Command(os.Args[0], "-update")
-	cmd.Stderr = os.Stderr
-	cmd.Start()
+	err := background.Start(config.Loc(config.UpdateLogFileName), "-update",
+		"-api", *apiFlag,
+		"-timeout", timeoutFlag.String(),
+		"-user-agent", *userAgentFlag,
+		"-ca-cert", *caCertFlag,
+		"-ttl", ttlFlag.String(),
+	)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to start update:", err)
+	}
 }
 
 func newEmos() (*emos.EmojiSearch, error) {
 	cfn := config.Loc(config.CacheFileName)
 	ifn := config.Loc(config.IndexFileName)
-	return emos.NewEmojiSearch(cfn, ifn)
+	return emos.NewEmojiSearch(cfn, ifn, emos.WithProvider(&emos.EmojiGG{
+		BaseURL:   *apiFlag,
+		Client:    httpClient,
+		UserAgent: *userAgentFlag,
+		Timeout:   *timeoutFlag,
+	}), emos.WithTTL(*ttlFlag), emos.WithCachedSetup())
+}
+
+func newHTTPClient(caCertFile string) (*http.Client, error) {
+	pem, err := ioutil.ReadFile(caCertFile)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read ca certificates: %w", err)
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in %s", caCertFile)
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	return &http.Client{Transport: transport}, nil
 }
 
 type alfredResult struct {
@@ -94,6 +157,7 @@ type alfredResult struct {
 type alfredItem struct {
 	UID          string `json:"uid"`
 	Title        string `json:"title"`
+	Subtitle     string `json:"subtitle,omitempty"`
 	Arg          string `json:"arg"`
 	QuickLookURL string `json:"quicklookurl"`
 	Text         struct {
@@ -128,13 +192,21 @@ func newAlfredItem(title, url, path string) *alfredItem {
 }
 
 func runSearch(input string) error {
-	emos, err := newEmos()
+	es, err := newEmos()
 	if err != nil {
 		return fmt.Errorf("unable to start emoji search: %w", err)
 	}
-	defer emos.Close()
-
-	iter := emos.Search(input)
+	defer es.Close()
+
+	iter, err := es.Search(input)
+	var syntaxErr *emos.SyntaxError
+	if errors.As(err, &syntaxErr) {
+		// alfred shows results as they're typed, half typed queries
+		// like `title:` shouldn't be errors
+		return json.NewEncoder(os.Stdout).Encode(alfredResult{Items: []*alfredItem{}})
+	} else if err != nil {
+		return fmt.Errorf("unable to search: %w", err)
+	}
 
 	aiChan := prepareResults(iter)
 
@@ -157,13 +229,21 @@ func prepareResults(iter *emos.SearchResultIter) <-chan *alfredItem {
 	for i := 0; i < workerCount; i++ {
 		g.Go(func() error {
 			for emoji := range workChan {
+				if emoji.Character != "" {
+					item := newAlfredItem(emoji.Title, emoji.Character, "")
+					item.Subtitle = emoji.Source
+					aiChan <- item
+					continue
+				}
 
 				imgPath, err := downloadImage(emoji)
 				if err != nil {
 					return err
 				}
 				name := emoji.Title
-				aiChan <- newAlfredItem(name, emoji.Image, imgPath)
+				item := newAlfredItem(name, emoji.Image, imgPath)
+				item.Subtitle = emoji.Source
+				aiChan <- item
 			}
 			return nil
 		})
@@ -200,7 +280,12 @@ func downloadImage(emoji *emos.Emoji) (string, error) {
 		return emojiPath, nil
 	}
 
-	resp, err := http.Get(emoji.Image)
+	req, err := http.NewRequest(http.MethodGet, emoji.Image, nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid emoji image url: %w", err)
+	}
+
+	resp, err := webclient.Do(httpClient, req, *userAgentFlag, *timeoutFlag)
 	if err != nil {
 		return "", fmt.Errorf("unable to download emoji image: %w", err)
 	}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/voldyman/emos"
+	"github.com/voldyman/emos/internal/config"
+)
+
+// runDiff prints the changes between two caches, `new` compares the cache
+// with the one it replaced and `diff old.json new.json` any two caches
+func runDiff(cmd string, args []string) error {
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	jsonFlag := fs.Bool("json", false, "print the changes as json")
+	fs.Parse(args)
+
+	var oldLoc, newLoc string
+	switch cmd {
+	case "new":
+		newLoc = config.Loc(config.CacheFileName)
+		oldLoc = emos.BackupLoc(newLoc)
+		if _, err := os.Stat(oldLoc); errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("no previous emojis to compare with, run emos -update first")
+		}
+	case "diff":
+		if fs.NArg() != 2 {
+			return fmt.Errorf("usage: emos diff [-json] old.json new.json")
+		}
+		oldLoc, newLoc = fs.Arg(0), fs.Arg(1)
+	}
+
+	old, err := emos.ReadCatalog(oldLoc)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", oldLoc, err)
+	}
+	current, err := emos.ReadCatalog(newLoc)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", newLoc, err)
+	}
+
+	diff := emos.DiffCatalogs(old, current)
+	if *jsonFlag {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(diff)
+	}
+
+	if diff.Empty() {
+		fmt.Println("no changes")
+		return nil
+	}
+	printDiffEntries("added", "+", diff.Added)
+	printDiffEntries("changed", "~", diff.Changed)
+	printDiffEntries("removed", "-", diff.Removed)
+	return nil
+}
+
+func printDiffEntries(heading, mark string, entries []emos.DiffEntry) {
+	if len(entries) == 0 {
+		return
+	}
+
+	fmt.Printf("%s (%d)\n", heading, len(entries))
+	for _, entry := range entries {
+		e := entry.Emoji
+		title := e.Title
+		if e.Category != "" {
+			title = fmt.Sprintf("%s [%s]", e.Title, e.Category)
+		}
+		fmt.Printf("  %s %s - %s\n", mark, title, emojiLink(e))
+	}
+}
@@ -1,24 +1,52 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/voldyman/emos"
+	"github.com/voldyman/emos/internal/background"
 	"github.com/voldyman/emos/internal/config"
 )
 
 var (
 	updateFlag   = flag.Bool("update", false, "update emoji list and index")
+	refreshFlag  = flag.Bool("refresh", false, "update like -update, also from the sources and with the compression of the last update")
 	markdownFlag = flag.Bool("md", false, "print markdown formatted link")
 	onlyLinkFlag = flag.Bool("link", false, "only prints the link")
 	luckyFlag    = flag.Bool("lucky", false, "only prints the first result")
 	conigDirFlag = flag.Bool("cfg", false, "prints the config dir")
+	unicodeFlag  = flag.Bool("unicode", false, "include standard unicode emojis when fetching emojis")
+	dirFlag      = flag.String("dir", "", "include the emoji images in this directory when fetching emojis")
+	baseURLFlag  = flag.String("dir-url", "", "base url for links to emojis from -dir, file links are used if empty")
+	slackFlag    = flag.String("slack", "", "include the emojis in this slack emoji.list export when fetching emojis")
+	discordFlag  = flag.String("discord", "", "include the emojis in this discord guild emoji json when fetching emojis")
+	apiFlag      = flag.String("api", emos.DefaultEmojiGGURL, "emoji.gg api url")
+	timeoutFlag  = flag.Duration("timeout", 30*time.Second, "timeout for each http request")
+	detailsFlag  = flag.Bool("details", false, "prints everything known about the emojis")
+	sortFlag     = flag.String("sort", "relevance", "order of results: relevance, faves, title or newest")
+	favesFlag    = flag.Float64("faves-weight", emos.DefaultFavesWeight, "how much faves boost relevance, 0 ranks on text alone")
+	groupFlag    = flag.Bool("group", false, "group emojis with the same title from different sources as variants")
+	preferFlag   = flag.String("prefer", "", "only keep the emoji from this source when sources have the same title, e.g. slack")
+	offlineFlag  = flag.Bool("offline", false, "never use the network, only search the emojis fetched before")
+	compressFlag = flag.String("compress", "none", "compression of the emoji cache: none, gzip or zstd")
+	ttlFlag      = flag.Duration("ttl", 24*time.Hour, "refresh emojis older than this in the background, 0 never does")
+	fuzzyFlag    = flag.Int("fuzzy", emos.DefaultFuzzyDistance, "how many typos are tolerated in titles, 0 turns typo tolerance off")
+	prefixFlag   = flag.Int("fuzzy-prefix", emos.DefaultFuzzyPrefix, "how many leading letters of a title have to be typed right")
+	regexFlag    = flag.Bool("regex", false, "search titles with a regular expression instead of words")
+	offsetFlag   = flag.Int("offset", 0, "skip this many results")
+	pageFlag     = flag.Int("page", 1, "print this page of results, pages have -n results")
+	limitFlag    int
 )
 
 func init() {
+	flag.IntVar(&limitFlag, "n", 20, "number of results to print")
+	flag.IntVar(&limitFlag, "limit", 20, "number of results to print, same as -n")
 	flag.Parse()
 }
 
@@ -33,15 +61,47 @@ func main() {
 		return
 	}
 
-	e, err := emos.NewEmojiSearch(config.Loc(config.CacheFileName), config.Loc(config.IndexFileName))
-	if err != nil {
-		panic(err)
+	if cmd := flag.Arg(0); cmd == "new" || cmd == "diff" {
+		if err := runDiff(cmd, flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	e, err := emos.NewEmojiSearch(config.Loc(config.CacheFileName), config.Loc(config.IndexFileName), searchOptions()...)
+	if errors.Is(err, emos.ErrNoLocalCatalog) {
+		fmt.Fprintln(os.Stderr, "no emojis have been downloaded yet, run emos -update without -offline first")
+		os.Exit(1)
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to start emoji search:", err)
+		os.Exit(1)
 	}
 	defer e.Close()
 
-	if e.IsIndexEmpty() || *updateFlag {
+	if *updateFlag || *refreshFlag {
+		var partial *emos.PartialError
+		if err := e.UpdateEmojis(); errors.As(err, &partial) {
+			fmt.Fprintln(os.Stderr, "some emojis could not be updated:", err)
+		} else if errors.Is(err, emos.ErrUpdateInProgress) {
+			fmt.Fprintln(os.Stderr, "emojis are already being updated by another emos")
+		} else if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to update emojis:", err)
+			os.Exit(1)
+		}
+	} else if e.NeedsRefresh() && !e.UpdateInProgress() {
+		// the current emojis are searched while they're refreshed
+		if err := background.Start(config.Loc(config.UpdateLogFileName), refreshArgs()...); err != nil {
+			fmt.Fprintln(os.Stderr, "unable to refresh emojis in the background:", err)
+		}
+	}
+
+	if e.IsIndexEmpty() {
 		fmt.Println("building index, this will take a minute. you should hydrate. :blobsweat:")
-		e.RefreshIndex()
+		if err := e.RefreshIndex(); err != nil {
+			fmt.Fprintln(os.Stderr, "unable to build index:", err)
+			os.Exit(1)
+		}
 	}
 
 	if text == "" {
@@ -49,43 +109,197 @@ func main() {
 		return
 	}
 
-	iter := e.Search(text)
-	emoji, err := iter.Next()
+	order, err := emos.ParseSortOrder(*sortFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	count := 20
+	limit := limitFlag
 	if *luckyFlag {
-		count = 1
+		limit = 1
+	}
+	offset := *offsetFlag
+	if *pageFlag > 1 {
+		offset += (*pageFlag - 1) * limit
+	}
+
+	opts := []emos.SearchOption{emos.SortBy(order), emos.Limit(limit), emos.Offset(offset)}
+	if *regexFlag {
+		opts = append(opts, emos.AsRegexp())
+	}
+	iter, err := e.Search(text, opts...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	emoji, err := iter.Next()
+
+	print := createPrintStatement
+	if len(e.Sources()) > 1 {
+		print = createPrintStatementWithSource
+	}
+	if *detailsFlag {
+		print = func(emoji *emos.Emoji) string {
+			return createDetailedStatement(e, emoji)
+		}
 	}
 
 	lines := []string{}
-	for i := 0; i < count && err == nil; i++ {
-		lines = append(lines, createPrintStatement(emoji))
+	for err == nil {
+		lines = append(lines, print(emoji))
 		emoji, err = iter.Next()
 	}
 
 	if isStdoutPiped() {
 		fmt.Printf("%s", strings.Join(lines, "\n"))
-	} else {
-		fmt.Println(strings.Join(lines, "\n"))
+		return
+	}
+	fmt.Println(strings.Join(lines, "\n"))
+	if shown := offset + len(lines); !*luckyFlag && shown < iter.Total {
+		fmt.Fprintf(os.Stderr, "%d of %d results, -page %d shows more\n", shown, iter.Total, *pageFlag+1)
 	}
 }
 
+// refreshArgs runs emos with the same flags and only updates, the sources
+// of earlier updates are kept even when they're not given this time
+func refreshArgs() []string {
+	args := []string{"-refresh"}
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name != "update" && f.Name != "refresh" {
+			args = append(args, fmt.Sprintf("-%s=%s", f.Name, f.Value))
+		}
+	})
+	return args
+}
+
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+func searchOptions() []emos.Option {
+	compression, err := emos.ParseCompression(*compressFlag)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	opts := []emos.Option{
+		emos.WithProvider(&emos.EmojiGG{BaseURL: *apiFlag, Timeout: *timeoutFlag}),
+		emos.WithFavesWeight(*favesFlag),
+		emos.WithFuzzy(*fuzzyFlag, *prefixFlag),
+		emos.WithOffline(*offlineFlag),
+		emos.WithTTL(*ttlFlag),
+	}
+	// the cache keeps its compression when refreshing unless it's changed
+	if isFlagSet("compress") || !*refreshFlag {
+		opts = append(opts, emos.WithCompression(compression))
+	}
+	if *refreshFlag {
+		opts = append(opts, emos.WithCachedSetup())
+	}
+	if *groupFlag {
+		opts = append(opts, emos.WithDuplicatePolicy(emos.GroupVariants))
+	}
+	if *preferFlag != "" {
+		opts = append(opts, emos.WithPreferredSource(*preferFlag))
+	}
+	if *unicodeFlag {
+		opts = append(opts, emos.WithProvider(&emos.Unicode{}))
+	}
+	if *dirFlag != "" {
+		opts = append(opts, emos.WithProvider(&emos.Directory{Path: *dirFlag, BaseURL: *baseURLFlag}))
+	}
+	if *slackFlag != "" {
+		opts = append(opts, emos.WithProvider(&emos.SlackExport{Path: *slackFlag}))
+	}
+	if *discordFlag != "" {
+		opts = append(opts, emos.WithProvider(&emos.DiscordGuild{Path: *discordFlag}))
+	}
+	return opts
+}
+
 func createPrintStatement(e *emos.Emoji) string {
+	return printStatement(e, e.Title)
+}
+
+func createPrintStatementWithSource(e *emos.Emoji) string {
+	return printStatement(e, fmt.Sprintf("%s [%s]", e.Title, e.Source))
+}
+
+func printStatement(e *emos.Emoji, title string) string {
 	var b strings.Builder
 	if !*onlyLinkFlag {
-		b.WriteString(e.Title)
+		b.WriteString(title)
 		b.WriteString(" - ")
 	}
-	if *markdownFlag {
+	if *markdownFlag && e.Character == "" && e.DiscordMarkup() == "" {
 		b.WriteString("/md ![](")
 		b.WriteString(e.Image)
 		b.WriteString(")")
 	} else {
-		b.WriteString(e.Image)
+		b.WriteString(emojiLink(e))
 	}
 	return b.String()
 }
 
+// emojiLink is what's pasted to use the emoji
+func emojiLink(e *emos.Emoji) string {
+	if e.Character != "" {
+		// unicode emojis are pasted as is, no link needed
+		return e.Character
+	}
+	if markup := e.DiscordMarkup(); markup != "" {
+		return markup
+	}
+	return e.Image
+}
+
+func createDetailedStatement(es *emos.EmojiSearch, e *emos.Emoji) string {
+	var b strings.Builder
+	b.WriteString(e.Title)
+	b.WriteString("\n")
+
+	field := func(name, value string) {
+		if value != "" {
+			fmt.Fprintf(&b, "  %-12s %s\n", name+":", value)
+		}
+	}
+	field("source", e.Source)
+	field("emoji", e.Character)
+	field("markup", e.DiscordMarkup())
+	field("image", e.Image)
+	field("slug", e.Slug)
+	field("category", e.Category)
+	field("description", e.Description)
+	field("aliases", strings.Join(e.Aliases, ", "))
+	field("keywords", strings.Join(e.Keywords, ", "))
+	field("author", e.Author)
+	field("license", e.License)
+	if e.Faves > 0 {
+		field("faves", strconv.Itoa(e.Faves))
+	}
+	if e.Width > 0 && e.Height > 0 {
+		field("size", fmt.Sprintf("%dx%d", e.Width, e.Height))
+	}
+	if e.FileSize > 0 {
+		field("file size", fmt.Sprintf("%.1f KB", float64(e.FileSize)/1024))
+	}
+	for _, id := range e.Variants {
+		if v, ok := es.Get(id); ok {
+			field("variant", fmt.Sprintf("[%s] %s", v.Source, printStatement(v, v.Title)))
+		}
+	}
+
+	return b.String()
+}
+
 func isStdoutPiped() bool {
 	info, err := os.Stdout.Stat()
 	if err != nil {
@@ -0,0 +1,88 @@
+package emos
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression of the cache file, compressed caches are recognized when
+// they're read whichever compression is configured
+type Compression int
+
+const (
+	// NoCompression writes the cache as plain json
+	NoCompression Compression = iota
+	// Gzip compresses the cache with gzip
+	Gzip
+	// Zstd compresses the cache with zstandard, it is faster to read
+	Zstd
+)
+
+var compressionNames = map[string]Compression{
+	"none": NoCompression,
+	"gzip": Gzip,
+	"zstd": Zstd,
+}
+
+// ParseCompression converts the name of a compression, e.g. "zstd"
+func ParseCompression(name string) (Compression, error) {
+	if c, ok := compressionNames[strings.ToLower(name)]; ok {
+		return c, nil
+	}
+	return NoCompression, fmt.Errorf("unknown compression %q", name)
+}
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// compressWriter compresses what's written to w, closing it flushes the
+// compressed data but leaves w open
+func compressWriter(w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		return zstd.NewWriter(w)
+	default:
+		return nopWriteCloser{w}, nil
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error {
+	return nil
+}
+
+// decompress recognizes the compression of data by its magic bytes, data
+// which isn't compressed is returned as is
+func decompress(data []byte) ([]byte, error) {
+	switch {
+	case bytes.HasPrefix(data, gzipMagic):
+		r, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return ioutil.ReadAll(r)
+
+	case bytes.HasPrefix(data, zstdMagic):
+		r, err := zstd.NewReader(nil)
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return r.DecodeAll(data, nil)
+	}
+	return data, nil
+}
@@ -0,0 +1,102 @@
+package emos
+
+import (
+	"reflect"
+	"sort"
+)
+
+// CatalogDiff lists the emojis which differ between two catalogs, each
+// list is ordered by id
+type CatalogDiff struct {
+	Added   []DiffEntry
+	Changed []DiffEntry
+	Removed []DiffEntry
+}
+
+// DiffEntry is an emoji which differs between two catalogs
+type DiffEntry struct {
+	ID string
+	// Emoji is the emoji in the new catalog, or the old one when removed
+	Emoji *Emoji
+	// Old is the emoji in the old catalog when it changed
+	Old *Emoji `json:",omitempty"`
+}
+
+// Empty reports whether the catalogs were the same
+func (d CatalogDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Changed) == 0 && len(d.Removed) == 0
+}
+
+// DiffCatalogs compares two catalogs keyed by emoji id, e.g. as read by
+// ReadCatalog
+func DiffCatalogs(old, new map[string]*Emoji) CatalogDiff {
+	ids := diffCatalogs(old, new)
+	diff := CatalogDiff{}
+	for _, id := range ids.added {
+		diff.Added = append(diff.Added, DiffEntry{ID: id, Emoji: new[id]})
+	}
+	for _, id := range ids.changed {
+		diff.Changed = append(diff.Changed, DiffEntry{ID: id, Emoji: new[id], Old: old[id]})
+	}
+	for _, id := range ids.removed {
+		diff.Removed = append(diff.Removed, DiffEntry{ID: id, Emoji: old[id]})
+	}
+	return diff
+}
+
+// catalogDiff lists the ids of emojis which differ between two catalogs
+type catalogDiff struct {
+	added   []string
+	changed []string
+	removed []string
+}
+
+func diffCatalogs(old, new map[string]*Emoji) catalogDiff {
+	diff := catalogDiff{}
+	for id, e := range new {
+		prev, ok := old[id]
+		if !ok {
+			diff.added = append(diff.added, id)
+		} else if !reflect.DeepEqual(prev, e) {
+			diff.changed = append(diff.changed, id)
+		}
+	}
+
+	for id := range old {
+		if _, ok := new[id]; !ok {
+			diff.removed = append(diff.removed, id)
+		}
+	}
+
+	sort.Strings(diff.added)
+	sort.Strings(diff.changed)
+	sort.Strings(diff.removed)
+	return diff
+}
+
+// applyDiff updates the index with the changes from the old catalog to
+// the current store
+func (es *EmojiSearch) applyDiff(diff catalogDiff) error {
+	w, err := es.index.Writer()
+	if err != nil {
+		return err
+	}
+
+	for _, id := range append(diff.added, diff.changed...) {
+		if err := w.Update(id, es.store[id]); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	for _, id := range diff.removed {
+		if err := w.Delete(id); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.SetGeneration(es.generation); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
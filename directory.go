@@ -0,0 +1,173 @@
+package emos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var imageExtensions = map[string]struct{}{
+	".png":  {},
+	".gif":  {},
+	".jpg":  {},
+	".jpeg": {},
+}
+
+// Directory provides emojis from a directory of image files. Every image
+// can have a sidecar file with the same name and a .json, .yaml or .yml
+// extension describing it.
+type Directory struct {
+	// Path of the directory, sub directories are included
+	Path string
+	// BaseURL is prepended to the relative path of an image to create its
+	// link, file:// links are used when empty
+	BaseURL string
+	// Prefix overrides the IDPrefix, needed when using several directories
+	Prefix string
+}
+
+// Name of the provider
+func (p *Directory) Name() string {
+	return fmt.Sprintf("directory %s", p.Path)
+}
+
+// IDPrefix of emojis from a directory
+func (p *Directory) IDPrefix() string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+	return "dir"
+}
+
+type emojiSidecar struct {
+	Description string   `json:"description" yaml:"description"`
+	Category    string   `json:"category" yaml:"category"`
+	Aliases     []string `json:"aliases" yaml:"aliases"`
+}
+
+// Fetch reads the emojis in the directory, images which can't be read
+// are skipped and returned in a *PartialError
+func (p *Directory) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	root, err := filepath.Abs(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid emoji directory: %w", err)
+	}
+
+	result := map[string]*Emoji{}
+	partial := &PartialError{}
+	err = filepath.Walk(root, func(fn string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ext := strings.ToLower(filepath.Ext(fn))
+		if _, ok := imageExtensions[ext]; !ok || info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(root, fn)
+		if err != nil {
+			return err
+		}
+		id := filepath.ToSlash(strings.TrimSuffix(rel, filepath.Ext(rel)))
+
+		emoji, err := p.readEmoji(fn, rel)
+		if err != nil {
+			// one broken image shouldn't lose the others
+			partial.add(filepath.ToSlash(rel), err)
+			return nil
+		}
+		result[id] = emoji
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to read emoji directory: %w", err)
+	}
+
+	if len(partial.Failed) > 0 {
+		return result, partial
+	}
+	return result, nil
+}
+
+func (p *Directory) readEmoji(fn, rel string) (*Emoji, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read image header: %w", err)
+	}
+
+	sidecar, err := readSidecar(strings.TrimSuffix(fn, filepath.Ext(fn)))
+	if err != nil {
+		return nil, err
+	}
+
+	category := sidecar.Category
+	if category == "" && filepath.Dir(rel) != "." {
+		category = filepath.ToSlash(filepath.Dir(rel))
+	}
+
+	return &Emoji{
+		Title:       strings.TrimSuffix(filepath.Base(fn), filepath.Ext(fn)),
+		Image:       p.imageLink(fn, rel),
+		Description: sidecar.Description,
+		Category:    category,
+		Width:       cfg.Width,
+		Height:      cfg.Height,
+		Aliases:     sidecar.Aliases,
+	}, nil
+}
+
+func (p *Directory) imageLink(fn, rel string) string {
+	if p.BaseURL == "" {
+		u := url.URL{Scheme: "file", Path: filepath.ToSlash(fn)}
+		return u.String()
+	}
+
+	u := url.URL{Path: path.Clean(filepath.ToSlash(rel))}
+	return strings.TrimSuffix(p.BaseURL, "/") + "/" + u.String()
+}
+
+// readSidecar reads the optional description of an emoji, base is the
+// path of the image without its extension
+func readSidecar(base string) (*emojiSidecar, error) {
+	sidecar := &emojiSidecar{}
+
+	if data, err := ioutil.ReadFile(base + ".json"); err == nil {
+		if err := json.Unmarshal(data, sidecar); err != nil {
+			return nil, fmt.Errorf("unable to decode %s.json: %w", base, err)
+		}
+		return sidecar, nil
+	}
+
+	for _, ext := range []string{".yaml", ".yml"} {
+		if data, err := ioutil.ReadFile(base + ext); err == nil {
+			if err := yaml.Unmarshal(data, sidecar); err != nil {
+				return nil, fmt.Errorf("unable to decode %s%s: %w", base, ext, err)
+			}
+			return sidecar, nil
+		}
+	}
+
+	return sidecar, nil
+}
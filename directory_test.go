@@ -0,0 +1,41 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDirectorySkipsUnreadableImages(t *testing.T) {
+	dir := t.TempDir()
+
+	f, err := os.Create(filepath.Join(dir, "blobwave.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := png.Encode(f, image.NewRGBA(image.Rect(0, 0, 32, 24))); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if err := ioutil.WriteFile(filepath.Join(dir, "broken.png"), []byte("not a png"), writePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	emojis, err := (&Directory{Path: dir}).Fetch(context.Background())
+
+	var partial *PartialError
+	if !errors.As(err, &partial) || partial.Failed["broken.png"] == nil {
+		t.Fatalf("expected the broken image in a partial error, got: %v", err)
+	}
+	if e := emojis["blobwave"]; e == nil || e.Width != 32 || e.Height != 24 {
+		t.Fatalf("expected the readable image to be imported, got: %+v", e)
+	}
+	if len(emojis) != 1 {
+		t.Fatalf("expected 1 emoji but got %d", len(emojis))
+	}
+}
@@ -1,42 +1,178 @@
 package emos
 
 import (
+	"bufio"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
+
+	"github.com/voldyman/emos/internal/webclient"
 )
 
-func fetchEmojis() (map[string]*Emoji, error) {
-	categories, err := fetchEmojiCategories()
-	if err != nil {
-		return nil, fmt.Errorf("unable to fetch emoji categories: %w", err)
+// DefaultEmojiGGURL is the api used when EmojiGG.BaseURL is empty
+const DefaultEmojiGGURL = "https://emoji.gg/api/"
+
+// EmojiGG provides the emoji catalog hosted on https://emoji.gg
+type EmojiGG struct {
+	// BaseURL of the emoji.gg api, DefaultEmojiGGURL is used when empty
+	BaseURL string
+	// Client used for requests, http.DefaultClient is used when nil
+	Client *http.Client
+	// UserAgent sent with requests, a default naming emos is used when empty
+	UserAgent string
+	// Timeout limits each request, it overrides the timeout of Client
+	Timeout time.Duration
+	// Retries is how often failed requests are retried, DefaultRetries is
+	// used when zero and negative values disable retrying
+	Retries int
+	// RetryBackoff is the base delay between retries, it doubles after
+	// every attempt. DefaultRetryBackoff is used when zero.
+	RetryBackoff time.Duration
+
+	// validators of the last responses, keyed by url
+	validators map[string]Validators
+	// cached emojis, their category names are used when the categories
+	// can't be fetched
+	cached map[string]*Emoji
+}
+
+// Name of the provider
+func (p *EmojiGG) Name() string {
+	return "emoji.gg"
+}
+
+// IDPrefix of emojis from emoji.gg
+func (p *EmojiGG) IDPrefix() string {
+	return "emojigg"
+}
+
+// Remote is true, the catalog is fetched from emoji.gg
+func (p *EmojiGG) Remote() bool {
+	return true
+}
+
+// Validators of the last fetched responses
+func (p *EmojiGG) Validators() map[string]Validators {
+	return p.validators
+}
+
+// SetValidators makes the next fetch conditional on the responses having
+// changed since the validators were saved
+func (p *EmojiGG) SetValidators(v map[string]Validators) {
+	p.validators = map[string]Validators{}
+	for u, validators := range v {
+		p.validators[u] = validators
+	}
+}
+
+// SetCached sets the emojis from the last fetch, their category names are
+// kept when the categories can't be fetched
+func (p *EmojiGG) SetCached(emojis map[string]*Emoji) {
+	p.cached = emojis
+}
+
+// Fetch downloads the emoji.gg catalog, ErrNotModified is returned when
+// validators are set and neither the emojis nor categories have changed.
+// When only the categories can't be fetched the emojis are returned with
+// the category names of the cached emojis, or numeric categories for new
+// ones, along with a *PartialError.
+func (p *EmojiGG) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	result := map[string]*Emoji{}
+	err := p.Stream(ctx, func(id string, e *Emoji) error {
+		result[id] = e
+		return nil
+	})
+
+	var partial *PartialError
+	if err != nil && !errors.As(err, &partial) {
+		return nil, err
+	}
+	return result, err
+}
+
+// Stream decodes the emoji.gg catalog while it is downloaded and passes
+// every emoji to fn, it returns the same errors as Fetch. Requests are
+// retried until the response starts, failures while reading the emojis
+// are returned as fn may already have been called.
+func (p *EmojiGG) Stream(ctx context.Context, fn func(id string, e *Emoji) error) error {
+	conditional := len(p.validators) > 0
+	partial := &PartialError{}
+
+	categories, err := p.fetchEmojiCategories(ctx, conditional)
+	categoriesUnchanged := errors.Is(err, ErrNotModified)
+	if err != nil && !categoriesUnchanged {
+		if ctx.Err() != nil {
+			return fmt.Errorf("unable to fetch emoji categories: %w", err)
+		}
+		partial.add("emoji.gg categories", err)
 	}
 
-	apiEmojis, err := fetchRawEmojis()
+	u, resp, err := p.open(ctx, nil, conditional)
+	if errors.Is(err, ErrNotModified) {
+		if categories == nil {
+			// unchanged or failed, either way the cached names are kept
+			return ErrNotModified
+		}
+		// the new category names have to be applied to every emoji
+		u, resp, err = p.open(ctx, nil, false)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("unable to fetch raw emojis: %w", err)
+		return fmt.Errorf("unable to fetch raw emojis: %w", err)
 	}
+	defer resp.Body.Close()
 
-	result := map[string]*Emoji{}
+	if categoriesUnchanged {
+		categories, err = p.fetchEmojiCategories(ctx, false)
+		if err != nil {
+			partial.add("emoji.gg categories", err)
+		}
+	}
+	if categories == nil {
+		// learned from the cached emojis below
+		categories = map[string]string{}
+	}
 
-	for _, e := range apiEmojis {
+	err = decodeEmojis(u, resp.Body, func(e *apiEmoji) error {
 		category := strconv.Itoa(e.Category)
 		if name, ok := categories[category]; ok {
 			category = name
+		} else if cached, ok := p.cached[strconv.Itoa(e.ID)]; ok && len(partial.Failed) > 0 {
+			// the emoji was cached with the name of its category
+			categories[category] = cached.Category
+			category = cached.Category
 		}
 
-		result[strconv.Itoa(e.ID)] = &Emoji{
+		return fn(strconv.Itoa(e.ID), &Emoji{
 			Title:       e.Title,
 			Image:       e.Image,
 			Description: e.Description,
 			Category:    category,
 			Width:       e.Width,
 			Height:      e.Height,
-		}
+			Slug:        e.Slug,
+			License:     e.License,
+			Faves:       e.Faves,
+			Author:      e.SubmittedBy,
+			FileSize:    e.FileSize,
+		})
+	})
+	if err != nil {
+		return fmt.Errorf("unable to read raw emojis: %w", err)
 	}
-	return result, nil
+	// the validators are only kept once the whole catalog was read
+	p.remember(u, resp)
+
+	if len(partial.Failed) > 0 {
+		return partial
+	}
+	return nil
 }
 
 type apiEmoji struct {
@@ -54,39 +190,160 @@ type apiEmoji struct {
 	FileSize    int    `json:"filesize"`
 }
 
-func fetchRawEmojis() ([]apiEmoji, error) {
-	resp, err := http.Get("https://emoji.gg/api/")
+// decodeEmojis decodes the json array of emojis one element at a time, so
+// the catalog never has to be held in memory as a whole
+func decodeEmojis(u string, r io.Reader, fn func(e *apiEmoji) error) error {
+	const expected = "a json array of emojis"
+
+	br := bufio.NewReader(r)
+	// kept to describe responses which aren't emojis
+	head, _ := br.Peek(64)
+	head = append([]byte(nil), head...)
+
+	dec := json.NewDecoder(br)
+	if err := expectDelim(dec, '['); err != nil {
+		return newDecodeError(u, expected, head, err)
+	}
+
+	for dec.More() {
+		e := &apiEmoji{}
+		if err := dec.Decode(e); err != nil {
+			return newDecodeError(u, expected, head, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	if err := expectDelim(dec, ']'); err != nil {
+		return newDecodeError(u, expected, head, err)
+	}
+	return nil
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	tok, err := dec.Token()
 	if err != nil {
-		return nil, err
+		return err
+	}
+	if tok != delim {
+		return fmt.Errorf("expected %v, found %v", delim, tok)
 	}
+	return nil
+}
 
-	jData, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+func (p *EmojiGG) fetchEmojiCategories(ctx context.Context, conditional bool) (map[string]string, error) {
+	u, jData, err := p.get(ctx, url.Values{"request": {"categories"}}, conditional)
 	if err != nil {
 		return nil, err
 	}
 
-	var result []apiEmoji
-	err = json.Unmarshal(jData, &result)
+	var result map[string]string
+	if err := json.Unmarshal(jData, &result); err != nil {
+		return nil, newDecodeError(u, "a json object of category names", jData, err)
+	}
+
+	return result, nil
+}
 
-	return result, err
+// get requests the api and reads the response, retrying temporary
+// failures. Conditional requests return ErrNotModified when the response
+// hasn't changed since the validators were saved.
+func (p *EmojiGG) get(ctx context.Context, query url.Values, conditional bool) (string, []byte, error) {
+	u, err := p.url(query)
+	if err != nil {
+		return "", nil, err
+	}
+
+	var body []byte
+	err = retry(ctx, p.Retries, p.RetryBackoff, func() error {
+		resp, err := p.do(ctx, u, conditional)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		body, err = ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		p.remember(u, resp)
+		return nil
+	})
+	return u, body, err
 }
 
-func fetchEmojiCategories() (map[string]string, error) {
-	resp, err := http.Get("https://emoji.gg/api/?request=categories")
+// open is get without reading the response, only the request is retried.
+// The caller closes the body and remembers the validators once it was read.
+func (p *EmojiGG) open(ctx context.Context, query url.Values, conditional bool) (string, *http.Response, error) {
+	u, err := p.url(query)
 	if err != nil {
-		return nil, err
+		return "", nil, err
 	}
 
-	jData, err := ioutil.ReadAll(resp.Body)
-	resp.Body.Close()
+	var resp *http.Response
+	err = retry(ctx, p.Retries, p.RetryBackoff, func() error {
+		var err error
+		resp, err = p.do(ctx, u, conditional)
+		return err
+	})
+	return u, resp, err
+}
+
+func (p *EmojiGG) url(query url.Values) (string, error) {
+	base := p.BaseURL
+	if base == "" {
+		base = DefaultEmojiGGURL
+	}
+	u, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid emoji.gg url: %w", err)
+	}
+	if len(query) > 0 {
+		u.RawQuery = query.Encode()
+	}
+	return u.String(), nil
+}
+
+// do sends a single request, the response is only returned when it's ok
+func (p *EmojiGG) do(ctx context.Context, u string, conditional bool) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var result map[string]string
-	err = json.Unmarshal(jData, &result)
+	if v, ok := p.validators[u]; ok && conditional {
+		if v.ETag != "" {
+			req.Header.Set("If-None-Match", v.ETag)
+		}
+		if v.LastModified != "" {
+			req.Header.Set("If-Modified-Since", v.LastModified)
+		}
+	}
 
-	return result, err
+	resp, err := webclient.Do(p.Client, req, p.UserAgent, p.Timeout)
+	if err != nil {
+		return nil, err
+	}
 
+	if resp.StatusCode == http.StatusNotModified {
+		resp.Body.Close()
+		return nil, ErrNotModified
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, newStatusError(resp)
+	}
+	return resp, nil
+}
+
+// remember keeps the validators of a response for the next fetch
+func (p *EmojiGG) remember(u string, resp *http.Response) {
+	if p.validators == nil {
+		p.validators = map[string]Validators{}
+	}
+	p.validators[u] = Validators{
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+	}
 }
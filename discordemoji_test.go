@@ -0,0 +1,303 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const (
+	testCategories = `{"1":"Anime"}`
+	testEmojis     = `[{"id":5,"title":"pepehug","category":1,"image":"https://emoji.gg/pepehug.png","faves":3}]`
+)
+
+// fakeEmojiGG serves the emoji.gg api, failing the first requests for the
+// emoji list with the given status
+func fakeEmojiGG(t *testing.T, failures int32, status int) (*httptest.Server, *int32) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("request") == "categories" {
+			fmt.Fprint(w, testCategories)
+			return
+		}
+
+		if atomic.AddInt32(&requests, 1) <= failures {
+			w.WriteHeader(status)
+			return
+		}
+		fmt.Fprint(w, testEmojis)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, &requests
+}
+
+func newTestEmojiGG(u string) *EmojiGG {
+	return &EmojiGG{BaseURL: u, RetryBackoff: time.Millisecond}
+}
+
+func TestFetchRetriesTemporaryFailures(t *testing.T) {
+	srv, requests := fakeEmojiGG(t, 2, http.StatusBadGateway)
+
+	emojis, err := newTestEmojiGG(srv.URL).Fetch(context.Background())
+	if err != nil {
+		t.Fatal("expected fetch to succeed after retrying, got:", err)
+	}
+
+	if *requests != 3 {
+		t.Fatalf("expected 3 requests but got %d", *requests)
+	}
+	if e := emojis["5"]; e == nil || e.Category != "Anime" {
+		t.Fatalf("unexpected emoji: %+v", e)
+	}
+}
+
+func TestFetchGivesUpAfterRetries(t *testing.T) {
+	srv, requests := fakeEmojiGG(t, 100, http.StatusServiceUnavailable)
+
+	p := newTestEmojiGG(srv.URL)
+	p.Retries = 2
+	_, err := p.Fetch(context.Background())
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected a status error, got: %v", err)
+	}
+	if *requests != 3 {
+		t.Fatalf("expected 3 requests but got %d", *requests)
+	}
+}
+
+func TestFetchDoesNotRetryClientErrors(t *testing.T) {
+	srv, requests := fakeEmojiGG(t, 100, http.StatusNotFound)
+
+	_, err := newTestEmojiGG(srv.URL).Fetch(context.Background())
+
+	var statusErr *StatusError
+	if !errors.As(err, &statusErr) || statusErr.Temporary() {
+		t.Fatalf("expected a permanent status error, got: %v", err)
+	}
+	if *requests != 1 {
+		t.Fatalf("expected 1 request but got %d", *requests)
+	}
+}
+
+func TestFetchUnexpectedBody(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("request") == "categories" {
+			fmt.Fprint(w, testCategories)
+			return
+		}
+		fmt.Fprint(w, `<html>maintenance</html>`)
+	}))
+	defer srv.Close()
+
+	_, err := newTestEmojiGG(srv.URL).Fetch(context.Background())
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a decode error, got: %v", err)
+	}
+	if decodeErr.Snippet != "<html>maintenance</html>" {
+		t.Fatalf("expected the body in the error, got: %q", decodeErr.Snippet)
+	}
+}
+
+func TestFetchWithoutCategories(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("request") == "categories" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, testEmojis)
+	}))
+	defer srv.Close()
+
+	p := newTestEmojiGG(srv.URL)
+	p.Retries = -1
+	emojis, err := p.Fetch(context.Background())
+
+	var partial *PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a partial error, got: %v", err)
+	}
+	if e := emojis["5"]; e == nil || e.Category != "1" {
+		t.Fatalf("expected the emoji with its category id, got: %+v", e)
+	}
+}
+
+func TestFetchCanceled(t *testing.T) {
+	srv, requests := fakeEmojiGG(t, 100, http.StatusBadGateway)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := newTestEmojiGG(srv.URL).Fetch(ctx)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected fetch to be canceled, got: %v", err)
+	}
+	if *requests != 0 {
+		t.Fatalf("expected no requests but got %d", *requests)
+	}
+}
+
+func TestStreamTruncatedCatalog(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("request") == "categories" {
+			fmt.Fprint(w, testCategories)
+			return
+		}
+		fmt.Fprint(w, testEmojis[:len(testEmojis)-1]+`,{"id":6,"tit`)
+	}))
+	defer srv.Close()
+
+	var ids []string
+	err := newTestEmojiGG(srv.URL).Stream(context.Background(), func(id string, e *Emoji) error {
+		ids = append(ids, id)
+		return nil
+	})
+
+	var decodeErr *DecodeError
+	if !errors.As(err, &decodeErr) {
+		t.Fatalf("expected a decode error, got: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != "5" {
+		t.Fatalf("expected the emojis before the error to be streamed, got: %v", ids)
+	}
+}
+
+// fakeResponse is what fakeCatalog serves for one endpoint, a request
+// with the etag gets a 304
+type fakeResponse struct {
+	body         string
+	etag         string
+	lastModified string
+	// status fails the request when set
+	status int
+
+	// the validators of the last request
+	ifNoneMatch     string
+	ifModifiedSince string
+}
+
+// fakeCatalog serves the emoji.gg api with validators
+func fakeCatalog(t *testing.T, categories, emojis *fakeResponse) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		resp := emojis
+		if r.URL.Query().Get("request") == "categories" {
+			resp = categories
+		}
+		resp.ifNoneMatch = r.Header.Get("If-None-Match")
+		resp.ifModifiedSince = r.Header.Get("If-Modified-Since")
+
+		if resp.status != 0 {
+			w.WriteHeader(resp.status)
+			return
+		}
+		if resp.etag != "" && resp.ifNoneMatch == resp.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", resp.etag)
+		if resp.lastModified != "" {
+			w.Header().Set("Last-Modified", resp.lastModified)
+		}
+		fmt.Fprint(w, resp.body)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestStreamKeepsCachedCategories(t *testing.T) {
+	categories := &fakeResponse{body: testCategories, etag: `"c1"`}
+	emojis := &fakeResponse{body: testEmojis, etag: `"e1"`}
+	srv := fakeCatalog(t, categories, emojis)
+
+	p := newTestEmojiGG(srv.URL)
+	p.Retries = -1
+	cached, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetCached(cached)
+
+	categories.status = http.StatusBadGateway
+	if _, err := p.Fetch(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected the unchanged catalog to keep its categories, got: %v", err)
+	}
+
+	emojis.body = testEmojis[:len(testEmojis)-1] + `,{"id":6,"title":"pepecry","category":1}]`
+	emojis.etag = `"e2"`
+	fetched, err := p.Fetch(context.Background())
+
+	var partial *PartialError
+	if !errors.As(err, &partial) {
+		t.Fatalf("expected a partial error, got: %v", err)
+	}
+	for _, id := range []string{"5", "6"} {
+		if e := fetched[id]; e == nil || e.Category != "Anime" {
+			t.Fatalf("expected emoji %s with the cached category name, got: %+v", id, e)
+		}
+	}
+}
+
+func TestFetchSendsValidators(t *testing.T) {
+	categories := &fakeResponse{body: testCategories, etag: `"c1"`}
+	emojis := &fakeResponse{body: testEmojis, etag: `"e1"`, lastModified: "Mon, 03 Oct 2022 10:00:00 GMT"}
+	srv := fakeCatalog(t, categories, emojis)
+
+	p := newTestEmojiGG(srv.URL)
+	if _, err := p.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if emojis.ifNoneMatch != "" || categories.ifNoneMatch != "" {
+		t.Fatal("expected the first fetch to be unconditional")
+	}
+
+	// validators survive a restart through SetValidators
+	next := newTestEmojiGG(srv.URL)
+	next.SetValidators(p.Validators())
+	_, err := next.Fetch(context.Background())
+	if !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected the catalog not to be modified, got: %v", err)
+	}
+
+	if categories.ifNoneMatch != `"c1"` || emojis.ifNoneMatch != `"e1"` {
+		t.Fatalf("expected If-None-Match to be sent, got %q and %q", categories.ifNoneMatch, emojis.ifNoneMatch)
+	}
+	if emojis.ifModifiedSince != "Mon, 03 Oct 2022 10:00:00 GMT" {
+		t.Fatalf("expected If-Modified-Since to be sent, got %q", emojis.ifModifiedSince)
+	}
+}
+
+func TestFetchChangedCategories(t *testing.T) {
+	categories := &fakeResponse{body: testCategories, etag: `"c1"`}
+	emojis := &fakeResponse{body: testEmojis, etag: `"e1"`}
+	srv := fakeCatalog(t, categories, emojis)
+
+	p := newTestEmojiGG(srv.URL)
+	if _, err := p.Fetch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	categories.body, categories.etag = `{"1":"Anime & Manga"}`, `"c2"`
+	fetched, err := p.Fetch(context.Background())
+	if err != nil {
+		t.Fatal("expected the unmodified catalog to be fetched again, got:", err)
+	}
+	if emojis.ifNoneMatch != "" {
+		t.Fatal("expected the catalog to be fetched unconditionally")
+	}
+	if e := fetched["5"]; e == nil || e.Category != "Anime & Manga" {
+		t.Fatalf("expected the new category name, got: %+v", e)
+	}
+
+	// both are cached now
+	if _, err := p.Fetch(context.Background()); !errors.Is(err, ErrNotModified) {
+		t.Fatalf("expected the catalog not to be modified, got: %v", err)
+	}
+}
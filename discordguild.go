@@ -0,0 +1,83 @@
+package emos
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+const discordCDN = "https://cdn.discordapp.com/emojis/"
+
+// DiscordGuild provides the custom emojis of a discord server from a saved
+// response of the guild emoji endpoint
+type DiscordGuild struct {
+	// Path of the json file, either the list of emojis or a guild object
+	Path string
+	// Prefix overrides the IDPrefix, needed when using several servers
+	Prefix string
+}
+
+// Name of the provider
+func (p *DiscordGuild) Name() string {
+	return fmt.Sprintf("discord guild %s", p.Path)
+}
+
+// IDPrefix of discord guild emojis
+func (p *DiscordGuild) IDPrefix() string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+	return "discord"
+}
+
+type discordEmoji struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Animated bool   `json:"animated"`
+}
+
+type discordGuild struct {
+	Name   string          `json:"name"`
+	Emojis []*discordEmoji `json:"emojis"`
+}
+
+// Fetch reads the saved guild emojis
+func (p *DiscordGuild) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read discord emojis: %w", err)
+	}
+
+	guild := discordGuild{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err = json.Unmarshal(data, &guild.Emojis)
+	} else {
+		err = json.Unmarshal(data, &guild)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode discord emojis: %w", err)
+	}
+
+	result := map[string]*Emoji{}
+	for _, e := range guild.Emojis {
+		// unicode emojis in reactions have no id
+		if e.ID == "" {
+			continue
+		}
+
+		ext := "png"
+		if e.Animated {
+			ext = "gif"
+		}
+		result[e.ID] = &Emoji{
+			Title:     e.Name,
+			Image:     fmt.Sprintf("%s%s.%s", discordCDN, e.ID, ext),
+			Category:  guild.Name,
+			DiscordID: e.ID,
+			Animated:  e.Animated,
+		}
+	}
+	return result, nil
+}
@@ -0,0 +1,43 @@
+package emos
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiscordGuildFormats(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     string
+		category string
+	}{
+		{"list", ` [{"id":"396521773144866826","name":"blobdance","animated":true},{"id":null,"name":"👍"}]`, ""},
+		{"guild", `{"name":"Blob Emoji","emojis":[{"id":"396521773144866826","name":"blobdance","animated":true}]}`, "Blob Emoji"},
+	}
+
+	for _, test := range tests {
+		fn := filepath.Join(t.TempDir(), "emojis.json")
+		if err := ioutil.WriteFile(fn, []byte(test.data), writePerms); err != nil {
+			t.Fatal(err)
+		}
+
+		emojis, err := (&DiscordGuild{Path: fn}).Fetch(context.Background())
+		if err != nil {
+			t.Fatalf("unable to read the %s format: %v", test.name, err)
+		}
+		if len(emojis) != 1 {
+			t.Fatalf("expected 1 emoji from the %s format but got %d", test.name, len(emojis))
+		}
+
+		e := emojis["396521773144866826"]
+		if e == nil || e.Title != "blobdance" || !e.Animated || e.Category != test.category ||
+			e.Image != discordCDN+"396521773144866826.gif" {
+			t.Fatalf("unexpected emoji from the %s format: %+v", test.name, e)
+		}
+		if e.DiscordMarkup() != "<a:blobdance:396521773144866826>" {
+			t.Fatalf("unexpected markup from the %s format: %s", test.name, e.DiscordMarkup())
+		}
+	}
+}
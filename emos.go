@@ -1,12 +1,15 @@
 package emos
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"os"
+	"sort"
+	"strings"
+	"time"
 
-	"github.com/voldyman/emos/internal/config"
+	"github.com/voldyman/emos/internal/query"
 )
 
 const (
@@ -20,140 +23,602 @@ type Emoji struct {
 	Category    string
 	Width       int
 	Height      int
+	// Character is set for Unicode emojis which don't need an image
+	Character string   `json:",omitempty"`
+	Keywords  []string `json:",omitempty"`
+	// Aliases are alternate titles the emoji can be found by
+	Aliases []string `json:",omitempty"`
+	// DiscordID is set for emojis of a discord server
+	DiscordID string `json:",omitempty"`
+	Animated  bool   `json:",omitempty"`
+	// Slug, License, Faves, Author and FileSize are provided by emoji.gg
+	Slug     string `json:",omitempty"`
+	License  string `json:",omitempty"`
+	Faves    int    `json:",omitempty"`
+	Author   string `json:",omitempty"`
+	FileSize int    `json:",omitempty"`
+	// Source is the IDPrefix of the provider the emoji came from
+	Source string `json:",omitempty"`
+	// Variants are the ids of emojis with the same title from other
+	// sources, which have VariantOf set and aren't searchable themselves
+	Variants  []string `json:",omitempty"`
+	VariantOf string   `json:",omitempty"`
 }
 
+// DiscordMarkup returns the text that displays the emoji in a discord
+// message, it is empty for emojis not from a discord server
+func (e *Emoji) DiscordMarkup() string {
+	if e.DiscordID == "" {
+		return ""
+	}
+	if e.Animated {
+		return fmt.Sprintf("<a:%s:%s>", e.Title, e.DiscordID)
+	}
+	return fmt.Sprintf("<:%s:%s>", e.Title, e.DiscordID)
+}
+
+// DefaultFavesWeight is how much faves boost relevance unless
+// configured with WithFavesWeight
+const DefaultFavesWeight = 0.1
+
+// DefaultLimit is the number of results returned by a search unless
+// configured with Limit
+const DefaultLimit = 50
+
+// DefaultFuzzyDistance and DefaultFuzzyPrefix are how typos in titles are
+// tolerated unless configured with WithFuzzy
+const (
+	DefaultFuzzyDistance = 2
+	DefaultFuzzyPrefix   = 1
+)
+
 type EmojiSearch struct {
 	emojiCacheLoc string
 	store         map[string]*Emoji
-	index         *index
+	// binary is used instead of store until every emoji is needed, so
+	// searches only decode the emojis they find
+	binary      *binaryCatalog
+	index       *index
+	providers   []Provider
+	duplicates  duplicateHandling
+	favesWeight float64
+	fuzzy       fuzziness
+	// generation of the cached catalog, see cacheHeader
+	generation int64
+	offline    bool
+	// lastUpdated is when the emojis were last fetched successfully
+	lastUpdated time.Time
+	ttl         time.Duration
+	compression Compression
+	// compressionSet is true when the compression was configured, it
+	// isn't taken from the cached setup then
+	compressionSet bool
+	cachedSetup    bool
+}
+
+// Option configures an EmojiSearch
+type Option func(*EmojiSearch)
+
+// WithProvider adds a source of emojis, the catalogs of all
+// providers are merged. emoji.gg is used when no provider is given.
+func WithProvider(p Provider) Option {
+	return func(es *EmojiSearch) {
+		es.providers = append(es.providers, p)
+	}
+}
+
+// WithDuplicatePolicy sets how emojis with the same title from different
+// providers are merged, all of them are kept by default
+func WithDuplicatePolicy(policy DuplicatePolicy) Option {
+	return func(es *EmojiSearch) {
+		es.duplicates.policy = policy
+	}
+}
+
+// WithPreferredSource keeps only the emoji from the provider with the given
+// IDPrefix when several providers have one with the same title
+func WithPreferredSource(prefix string) Option {
+	return func(es *EmojiSearch) {
+		es.duplicates = duplicateHandling{policy: PreferSource, preferred: prefix}
+	}
+}
+
+// WithFavesWeight sets how much the faves of an emoji boost its relevance,
+// the score is multiplied by 1 + weight * ln(1 + faves). Zero ranks on the
+// text alone.
+func WithFavesWeight(weight float64) Option {
+	return func(es *EmojiSearch) {
+		es.favesWeight = weight
+	}
+}
+
+// WithFuzzy matches titles with typos, words can be up to distance edits
+// away from a title but their first prefix letters have to be right.
+// Short words allow fewer edits, so "cry" doesn't match every three letter
+// title. A distance of zero only matches titles as they're typed, at most
+// two edits are tolerated.
+func WithFuzzy(distance, prefix int) Option {
+	return func(es *EmojiSearch) {
+		es.fuzzy = fuzziness{distance: distance, prefix: prefix}
+	}
+}
+
+// WithOffline never touches the network, emojis of remote providers are
+// only read from the cache and ErrNoLocalCatalog is returned without one
+func WithOffline(offline bool) Option {
+	return func(es *EmojiSearch) {
+		es.offline = offline
+	}
+}
+
+// WithTTL sets how long fetched emojis are fresh, see NeedsRefresh. Zero
+// keeps them fresh forever.
+func WithTTL(ttl time.Duration) Option {
+	return func(es *EmojiSearch) {
+		es.ttl = ttl
+	}
+}
+
+// WithCompression compresses the cache when it's written, caches are
+// read whether they're compressed or not
+func WithCompression(c Compression) Option {
+	return func(es *EmojiSearch) {
+		es.compression = c
+		es.compressionSet = true
+	}
+}
+
+// WithCachedSetup also fetches from the providers the cache was last
+// written with, so updating doesn't drop the emojis of sources which
+// aren't configured this time. The cache's compression is kept unless
+// WithCompression is given.
+func WithCachedSetup() Option {
+	return func(es *EmojiSearch) {
+		es.cachedSetup = true
+	}
 }
 
-func NewEmojiSearch(cacheLoc, indexLoc string) (*EmojiSearch, error) {
-	store, err := getEmojis(cacheLoc)
+func NewEmojiSearch(cacheLoc, indexLoc string, opts ...Option) (*EmojiSearch, error) {
+	es := &EmojiSearch{
+		emojiCacheLoc: cacheLoc,
+		favesWeight:   DefaultFavesWeight,
+		fuzzy:         fuzziness{distance: DefaultFuzzyDistance, prefix: DefaultFuzzyPrefix},
+	}
+	for _, opt := range opts {
+		opt(es)
+	}
+	if len(es.providers) == 0 {
+		es.providers = []Provider{&EmojiGG{}}
+	}
+
+	idx, err := getIndex(indexLoc)
 	if err != nil {
 		return nil, fmt.Errorf("unable to create emoji search: %w", err)
 	}
+	es.index = idx
+	if info, err := os.Stat(cacheLoc); err == nil {
+		es.lastUpdated = info.ModTime()
+	}
 
-	idx, err := getIndex(indexLoc)
+	if es.openBinary() {
+		return es, nil
+	}
+
+	cache, err := es.getEmojis(context.Background())
 	if err != nil {
 		return nil, fmt.Errorf("unable to create emoji search: %w", err)
 	}
+	es.store = cache.Emojis
+	es.generation = cache.Generation
 
-	return &EmojiSearch{
-		emojiCacheLoc: cacheLoc,
-		store:         store,
-		index:         idx,
-	}, nil
+	if es.IsIndexEmpty() || es.UpdateInProgress() {
+		// while updating the index is ahead of the cache
+		return es, nil
+	}
+	// an update was interrupted or the cache was migrated
+	gen, ok, err := idx.Generation()
+	if err != nil || !ok || gen != es.generation {
+		if err := es.RefreshIndex(); err != nil {
+			return nil, fmt.Errorf("unable to rebuild index for the cache: %w", err)
+		}
+	}
+	return es, nil
+}
+
+// openBinary uses the binary catalog when it was written with the cache
+// the index matches, the cache doesn't have to be decoded then
+func (es *EmojiSearch) openBinary() bool {
+	b, err := openBinaryCatalog(binaryLoc(es.emojiCacheLoc))
+	if err != nil {
+		return false
+	}
+
+	gen, ok, err := es.index.Generation()
+	if err != nil || !ok || gen != b.generation {
+		b.Close()
+		return false
+	}
+	es.binary, es.generation = b, b.generation
+	return true
+}
+
+// loadStore decodes every emoji of the binary catalog, which is needed to
+// update the emojis or the index
+func (es *EmojiSearch) loadStore() error {
+	if es.binary == nil {
+		return nil
+	}
+
+	store, err := es.binary.All()
+	if err != nil {
+		return fmt.Errorf("unable to read binary catalog: %w", err)
+	}
+	es.store = store
+	es.binary.Close()
+	es.binary = nil
+	return nil
+}
+
+// lookup finds the emoji in the store or decodes it from the binary catalog
+func (es *EmojiSearch) lookup(id string) (*Emoji, bool) {
+	if es.binary == nil {
+		e, ok := es.store[id]
+		return e, ok
+	}
+
+	e, ok, err := es.binary.Get(id)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "unable to read emoji:", err)
+	}
+	return e, ok
+}
+
+// LastUpdated is when the emojis were last fetched successfully, whether
+// or not they had changed
+func (es *EmojiSearch) LastUpdated() time.Time {
+	return es.lastUpdated
+}
+
+// NeedsRefresh reports whether the emojis are older than the TTL. It is
+// always false when offline or without a TTL.
+func (es *EmojiSearch) NeedsRefresh() bool {
+	if es.offline || es.ttl <= 0 {
+		return false
+	}
+	return time.Since(es.lastUpdated) > es.ttl
 }
 
 func (es *EmojiSearch) IsIndexEmpty() bool {
 	return es.index.Count() == 0
 }
 
+// Sources returns the prefixes of the providers the emojis came from
+func (es *EmojiSearch) Sources() []string {
+	seen := map[string]struct{}{}
+	if es.binary != nil {
+		// ids are namespaced by source, so no emoji has to be decoded
+		ids, err := es.binary.IDs()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "unable to read emoji ids:", err)
+		}
+		for _, id := range ids {
+			if i := strings.Index(id, ":"); i >= 0 {
+				seen[id[:i]] = struct{}{}
+			}
+		}
+	}
+	for _, e := range es.store {
+		seen[e.Source] = struct{}{}
+	}
+
+	sources := make([]string, 0, len(seen))
+	for s := range seen {
+		sources = append(sources, s)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// Get returns the emoji with the namespaced id, e.g. a variant
+func (es *EmojiSearch) Get(id string) (*Emoji, bool) {
+	return es.lookup(id)
+}
+
 type SearchResultIter struct {
 	Query string
+	// Total is the number of emojis matching the query, Next stops
+	// after the limit of the search
+	Total int
 	es    *EmojiSearch
 	iter  *searchIter
 }
 
+// Next returns the next emoji found. Emojis which an update running in
+// another process indexed before its cache replaced this search's are
+// skipped.
 func (si *SearchResultIter) Next() (*Emoji, error) {
-	docID, err := si.iter.Next()
-	if err != nil {
-		return nil, fmt.Errorf("stop searching: %w", err)
+	for {
+		docID, err := si.iter.Next()
+		if err != nil {
+			return nil, fmt.Errorf("stop searching: %w", err)
+		}
+
+		if emoji, ok := si.es.lookup(docID); ok {
+			return emoji, nil
+		}
+	}
+}
+
+// SearchOption changes how a single search behaves
+type SearchOption func(*searchOptions)
+
+// SortBy orders the results, they are sorted by relevance by default
+func SortBy(order SortOrder) SearchOption {
+	return func(opts *searchOptions) {
+		opts.sort = order
 	}
+}
+
+// Limit returns at most n results, DefaultLimit unless set
+func Limit(n int) SearchOption {
+	return func(opts *searchOptions) {
+		if n > 0 {
+			opts.limit = n
+		}
+	}
+}
 
-	if emoji, ok := si.es.store[docID]; ok {
-		return emoji, nil
+// Offset skips the first n results, Offset(20) with Limit(20) returns the
+// second page of results
+func Offset(n int) SearchOption {
+	return func(opts *searchOptions) {
+		if n > 0 {
+			opts.offset = n
+		}
 	}
+}
 
-	return nil, fmt.Errorf("invalid state, docID: %s not found in store", docID)
+// AsRegexp searches for titles matching the query as a regular
+// expression, e.g. `^pepe.*(hug|love)$`, ignoring case
+func AsRegexp() SearchOption {
+	return func(opts *searchOptions) {
+		opts.regexp = true
+	}
 }
 
-func (es *EmojiSearch) Search(input string) *SearchResultIter {
-	iter, err := es.index.Search(input)
+// SyntaxError is returned by Search when the query can't be parsed
+type SyntaxError = query.SyntaxError
+
+// Search finds the emojis matching the query. Words are matched anywhere,
+// "title:", "category:", "desc:", "author:", "license:" and "source:"
+// restrict a word to that field, "-word" excludes it, quoted text is
+// matched as a phrase and AND, OR and parentheses combine them.
+func (es *EmojiSearch) Search(input string, opts ...SearchOption) (*SearchResultIter, error) {
+	options := searchOptions{
+		sort:        SortRelevance,
+		favesWeight: es.favesWeight,
+		fuzzy:       es.fuzzy,
+		limit:       DefaultLimit,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	iter, err := es.index.Search(input, options)
 	if err != nil {
-		fmt.Println("unable to search", err)
-		return nil
+		return nil, err
 	}
 	result := &SearchResultIter{
 		Query: input,
+		Total: iter.total,
 		iter:  iter,
 		es:    es,
 	}
-	return result
+	return result, nil
 }
 
 // Close closes the search
 func (es *EmojiSearch) Close() {
 	es.index.Close()
+	if es.binary != nil {
+		es.binary.Close()
+	}
 }
 
-// RefreshIndex updates the index
-func (es *EmojiSearch) RefreshIndex() {
-	es.index.IndexEmojiStore(es.store)
+// RefreshIndex updates the index, emojis no longer in the store are removed
+func (es *EmojiSearch) RefreshIndex() error {
+	if err := es.loadStore(); err != nil {
+		return err
+	}
+
+	var ids []string
+	if !es.IsIndexEmpty() {
+		var err error
+		if ids, err = es.index.IDs(); err != nil {
+			return err
+		}
+	}
+
+	w, err := es.index.Writer()
+	if err != nil {
+		return err
+	}
+
+	for id, e := range es.store {
+		if err := w.Update(id, e); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	for _, id := range ids {
+		if _, ok := es.store[id]; ok {
+			continue
+		}
+		if err := w.Delete(id); err != nil {
+			w.Close()
+			return err
+		}
+	}
+	if err := w.SetGeneration(es.generation); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
 }
 
-// UpdateEmojis refreshes the local cache of emojis
+// UpdateEmojis refreshes the local cache of emojis and applies the
+// changes to the index
 func (es *EmojiSearch) UpdateEmojis() error {
-	f, err := ioutil.TempFile("", "temp-emoji")
+	return es.UpdateEmojisContext(context.Background())
+}
+
+// UpdateEmojisContext is UpdateEmojis with a context to cancel fetching.
+// When some providers fail the emojis from the others are still updated
+// and a *PartialError is returned. Only one process updates at a time,
+// ErrUpdateInProgress is returned while another one is.
+func (es *EmojiSearch) UpdateEmojisContext(ctx context.Context) error {
+	release, err := acquireLock(lockLoc(es.emojiCacheLoc))
 	if err != nil {
-		return fmt.Errorf("unable to create temp file for caching emojis: %w", err)
+		return err
 	}
-	defer func() {
-		f.Close()
-		os.Remove(f.Name())
-	}()
+	defer release()
 
-	_, err = updateEmojis(f.Name())
-	if err != nil {
-		return fmt.Errorf("unable to fetch new emojis: %w", err)
+	if err := es.loadStore(); err != nil {
+		return err
+	}
+	if es.cachedSetup {
+		es.useCachedSetup()
 	}
 
-	return os.Rename(f.Name(), config.Loc(config.CacheFileName))
-}
+	// validators are only useful while the catalog they belong to is cached
+	if len(es.store) > 0 {
+		if err := loadValidators(validatorsLoc(es.emojiCacheLoc), es.providers); err != nil {
+			fmt.Fprintln(os.Stderr, "ignoring saved validators:", err)
+		}
+	}
+	setCachedEmojis(es.providers, es.store)
+
+	old, oldGeneration := es.store, es.generation
+	generation := oldGeneration + 1
+
+	providers := reachableProviders(es.providers, es.offline)
+
+	var store map[string]*Emoji
+	p, streamed := streamingProvider(providers)
+	if streamed {
+		store, err = es.streamCatalog(ctx, p, generation)
+	} else {
+		store, err = fetchCatalog(ctx, providers, es.duplicates, es.store)
+	}
+	if errors.Is(err, ErrNotModified) {
+		// already up to date, the cache's time records the check
+		now := time.Now()
+		if err := os.Chtimes(es.emojiCacheLoc, now, now); err != nil {
+			fmt.Fprintln(os.Stderr, "unable to record update time, ignoring:", err)
+		}
+		es.lastUpdated = now
+		return nil
+	}
+	var partial *PartialError
+	if err != nil && !(errors.As(err, &partial) && store != nil) {
+		return fmt.Errorf("unable to fetch new emojis: %w", err)
+	}
 
-func getEmojis(cacheLoc string) (map[string]*Emoji, error) {
-	_, err := os.Stat(cacheLoc)
+	es.store, es.generation = store, generation
+	if streamed {
+		// the changes were indexed while streaming
+		err = nil
+	} else if es.IsIndexEmpty() {
+		err = es.RefreshIndex()
+	} else {
+		err = es.applyDiff(diffCatalogs(old, store))
+	}
 
+	// the index is written first, when the cache can't be replaced the
+	// generations differ and the index is rebuilt from the old cache
 	if err == nil {
-		if emojis, err := readEmojis(cacheLoc); err == nil {
-			return emojis, nil
+		err = writeCache(es.emojiCacheLoc, newCache(es.providers, store, generation), es.compression)
+	}
+	if err != nil {
+		es.store, es.generation = old, oldGeneration
+		if restoreErr := es.applyDiff(diffCatalogs(store, old)); restoreErr != nil {
+			fmt.Fprintln(os.Stderr, "unable to restore index:", restoreErr)
 		}
+		return fmt.Errorf("unable to update emojis: %w", err)
 	}
 
-	return updateEmojis(cacheLoc)
+	es.lastUpdated = time.Now()
+	if err := saveValidators(validatorsLoc(es.emojiCacheLoc), es.providers); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to save validators, ignoring:", err)
+	}
+	if partial != nil {
+		return partial
+	}
+	return nil
 }
 
-func readEmojis(cacheLoc string) (map[string]*Emoji, error) {
-	f, err := ioutil.ReadFile(cacheLoc)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read cache: %w", err)
+// getEmojis reads the cached emojis or fetches them. Unreadable caches are
+// moved aside and the backup of the previous cache is used if there is one.
+// When offline ErrNoLocalCatalog is returned instead of fetching.
+func (es *EmojiSearch) getEmojis(ctx context.Context) (*cacheFile, error) {
+	cacheLoc := es.emojiCacheLoc
+	cache, migrated, err := readCache(cacheLoc)
+	switch {
+	case err == nil:
+		if migrated {
+			if err := writeCache(cacheLoc, cache, es.compression); err != nil {
+				fmt.Fprintln(os.Stderr, "unable to save migrated cache, ignoring:", err)
+			}
+		}
+		return cache, nil
+
+	case errors.Is(err, errCorruptCache):
+		dest, qerr := quarantineCache(cacheLoc)
+		if qerr != nil {
+			return nil, fmt.Errorf("unable to move aside unreadable cache: %w", qerr)
+		}
+		fmt.Fprintf(os.Stderr, "moved unreadable cache to %s: %v\n", dest, err)
+
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, err
 	}
 
-	result := map[string]*Emoji{}
-	err = json.Unmarshal(f, &result)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode cache: %w", err)
+	if cache, ok := restoreBackup(cacheLoc); ok {
+		fmt.Fprintln(os.Stderr, "restored the previous emoji cache")
+		return cache, nil
+	}
+	if es.offline {
+		return nil, ErrNoLocalCatalog
 	}
 
-	return result, nil
+	cache, err = es.fetchEmojis(ctx)
+	var partial *PartialError
+	if errors.As(err, &partial) && cache != nil {
+		fmt.Fprintln(os.Stderr, "some emojis could not be fetched:", partial)
+	} else if err != nil {
+		return nil, err
+	}
 
+	if err := saveValidators(validatorsLoc(cacheLoc), es.providers); err != nil {
+		fmt.Fprintln(os.Stderr, "unable to save validators, ignoring:", err)
+	}
+	return cache, nil
 }
 
-func updateEmojis(cacheLoc string) (map[string]*Emoji, error) {
-	emojis, err := fetchEmojis()
-	if err != nil {
-		return nil, fmt.Errorf("failed to fetch emojis: %w", err)
+// fetchEmojis fetches the first catalog when nothing is cached yet
+func (es *EmojiSearch) fetchEmojis(ctx context.Context) (*cacheFile, error) {
+	emojis, fetchErr := fetchCatalog(ctx, es.providers, es.duplicates, nil)
+	if emojis == nil {
+		return nil, fmt.Errorf("failed to fetch emojis: %w", fetchErr)
 	}
 
-	d, err := json.Marshal(emojis)
-	if err == nil {
-		ioutil.WriteFile(cacheLoc, d, writePerms)
-	} else {
+	cache := newCache(es.providers, emojis, 1)
+	if err := writeCache(es.emojiCacheLoc, cache, es.compression); err != nil {
 		fmt.Println("unable to cache emojis, ignoring")
 	}
 
-	return emojis, nil
+	// partial failures are passed on with the emojis which were fetched
+	return cache, fetchErr
 }
 
 func getIndex(indexLoc string) (*index, error) {
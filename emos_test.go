@@ -0,0 +1,106 @@
+package emos
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/blugelabs/bluge"
+)
+
+func TestSearchSkipsEmojisOfRunningUpdate(t *testing.T) {
+	srv, _ := fakeEmojiGG(t, 0, http.StatusOK)
+	dir := t.TempDir()
+	es, err := NewEmojiSearch(filepath.Join(dir, "emoji.json"), filepath.Join(dir, "index"),
+		WithProvider(newTestEmojiGG(srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	if err := es.RefreshIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	// indexed by another process which hasn't replaced the cache yet
+	if err := es.index.IndexEmoji("emojigg:6", &Emoji{Title: "pepehug", Faves: 100}); err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := es.Search("pepehug")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := iter.Next()
+	if err != nil || e.Title != "pepehug" || e.Faves != 3 {
+		t.Fatalf("expected the cached emoji, got: %+v, %v", e, err)
+	}
+	if _, err := iter.Next(); err == nil {
+		t.Fatal("expected no more results")
+	}
+}
+
+func TestNewEmojiSearchRebuildsOldSchema(t *testing.T) {
+	dir := t.TempDir()
+	loc, indexLoc := filepath.Join(dir, "emoji.json"), filepath.Join(dir, "index")
+
+	emojis := map[string]*Emoji{"emojigg:5": {Title: "pepehug", Description: "a sad cat", Source: "emojigg"}}
+	if err := writeCache(loc, newCache(nil, emojis, 1), NoCompression); err != nil {
+		t.Fatal(err)
+	}
+
+	// written like the first schema, without term positions or a version
+	w, err := bluge.OpenWriter(bluge.DefaultConfig(indexLoc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := bluge.NewDocument("emojigg:5").
+		AddField(bluge.NewTextField(descriptionField, "a sad cat").WithAnalyzer(textAnalyzer))
+	meta := bluge.NewDocument(metaDocID).
+		AddField(bluge.NewNumericField(generationField, 1).StoreValue())
+	if err := w.Insert(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Insert(meta); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+
+	es, err := NewEmojiSearch(loc, indexLoc, WithOffline(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+
+	iter, err := es.Search(`"sad cat"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, err := iter.Next(); err != nil || e.Title != "pepehug" {
+		t.Fatalf("expected the rebuilt index to match phrases, got: %+v, %v", e, err)
+	}
+}
+
+func TestSearchLimitAndOffset(t *testing.T) {
+	srv, _ := fakeEmojiGG(t, 0, http.StatusOK)
+	dir := t.TempDir()
+	es, err := NewEmojiSearch(filepath.Join(dir, "emoji.json"), filepath.Join(dir, "index"),
+		WithProvider(newTestEmojiGG(srv.URL)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	if err := es.RefreshIndex(); err != nil {
+		t.Fatal(err)
+	}
+
+	iter, err := es.Search("pepehug", Limit(1), Offset(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iter.Total != 1 {
+		t.Fatalf("expected 1 match in total, got %d", iter.Total)
+	}
+	if e, err := iter.Next(); err == nil {
+		t.Fatalf("expected the only match to be skipped, got: %+v", e)
+	}
+}
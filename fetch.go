@@ -0,0 +1,154 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetries is how often failed requests are retried
+	DefaultRetries = 3
+	// DefaultRetryBackoff is the base delay before retrying a request
+	DefaultRetryBackoff = 500 * time.Millisecond
+
+	maxRetryBackoff = 10 * time.Second
+)
+
+// StatusError is returned when a server responds with an unexpected status
+type StatusError struct {
+	URL        string
+	StatusCode int
+	// RetryAfter is the delay requested by the server, if any
+	RetryAfter time.Duration
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("unexpected response from %s: %d %s", e.URL, e.StatusCode, http.StatusText(e.StatusCode))
+}
+
+// Temporary reports whether the request may succeed when retried
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+func newStatusError(resp *http.Response) *StatusError {
+	err := &StatusError{
+		URL:        resp.Request.URL.String(),
+		StatusCode: resp.StatusCode,
+	}
+	if secs, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+		err.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return err
+}
+
+// DecodeError is returned when a response isn't in the expected shape
+type DecodeError struct {
+	URL string
+	// Expected describes the shape the response should have had
+	Expected string
+	// Snippet is the start of the response body
+	Snippet string
+	Err     error
+}
+
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("response from %s is not %s (starts with %q): %v", e.URL, e.Expected, e.Snippet, e.Err)
+}
+
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+func newDecodeError(u, expected string, body []byte, err error) *DecodeError {
+	const snippetLen = 64
+	snippet := string(body)
+	if len(snippet) > snippetLen {
+		snippet = snippet[:snippetLen] + "..."
+	}
+	return &DecodeError{URL: u, Expected: expected, Snippet: snippet, Err: err}
+}
+
+// PartialError reports the parts of an update which failed while the rest
+// could still be used, e.g. one of several providers being unreachable
+type PartialError struct {
+	// Failed maps what failed to why, e.g. the name of a provider
+	Failed map[string]error
+}
+
+func (e *PartialError) Error() string {
+	parts := make([]string, 0, len(e.Failed))
+	for name, err := range e.Failed {
+		parts = append(parts, fmt.Sprintf("%s: %v", name, err))
+	}
+	sort.Strings(parts)
+	return fmt.Sprintf("partially failed, %s", strings.Join(parts, "; "))
+}
+
+func (e *PartialError) add(name string, err error) {
+	if e.Failed == nil {
+		e.Failed = map[string]error{}
+	}
+	e.Failed[name] = err
+}
+
+// retryable reports whether a failed request is worth retrying
+func retryable(err error) bool {
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Temporary()
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	// the connection broke while reading the body
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retry calls fn until it succeeds, fails permanently, the context is done
+// or the retries run out. The delay between attempts grows exponentially
+// with full jitter, so many clients don't retry in lockstep.
+func retry(ctx context.Context, retries int, backoff time.Duration, fn func() error) error {
+	if retries == 0 {
+		retries = DefaultRetries
+	}
+	if backoff <= 0 {
+		backoff = DefaultRetryBackoff
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= retries || !retryable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff << uint(attempt)
+		if delay > maxRetryBackoff || delay <= 0 {
+			delay = maxRetryBackoff
+		}
+		delay = time.Duration(rand.Int63n(int64(delay)) + 1)
+
+		var statusErr *StatusError
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > delay && statusErr.RetryAfter <= maxRetryBackoff {
+			delay = statusErr.RetryAfter
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
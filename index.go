@@ -3,23 +3,105 @@ package emos
 import (
 	"context"
 	"fmt"
-
+	"math"
+	"regexp/syntax"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	vellumregexp "github.com/blevesearch/vellum/regexp"
 	"github.com/blugelabs/bluge"
 	"github.com/blugelabs/bluge/analysis"
 	"github.com/blugelabs/bluge/analysis/token"
 	"github.com/blugelabs/bluge/analysis/tokenizer"
+	blugeindex "github.com/blugelabs/bluge/index"
 	"github.com/blugelabs/bluge/search"
+	"github.com/voldyman/emos/internal/query"
 )
 
-const maxBatchSize = 30
+// metaDocID is the document which records the generation of the cache the
+// index was built from, it has none of the searchable fields
+const metaDocID = "_meta"
+
+// indexSchema is the version of the documents in the index, indexes with
+// another version are rebuilt. Version 2 keeps term positions for phrases.
+const indexSchema = 2
+
+// maxFuzzyDistance is the most edits bluge can match
+const maxFuzzyDistance = 2
+
+// maxBatchSize is the number of documents written to the index at once
+const maxBatchSize = 500
 
 const (
 	titleField       = "Title"
 	titleNGField     = "TitleNG"
 	categoryField    = "Category"
 	descriptionField = "Description"
+	keywordsField    = "Keywords"
+	licenseField     = "License"
+	authorField      = "Author"
+	favesField       = "Faves"
+	titleSortField   = "TitleSort"
+	addedField       = "Added"
+	generationField  = "Generation"
+	schemaField      = "Schema"
+	sourceField      = "Source"
+)
+
+// SortOrder decides the order of search results
+type SortOrder int
+
+const (
+	// SortRelevance orders by how well emojis match, boosted by their faves
+	SortRelevance SortOrder = iota
+	// SortFaves orders the most faved emojis first
+	SortFaves
+	// SortTitle orders alphabetically by title
+	SortTitle
+	// SortNewest orders the most recently added emojis first
+	SortNewest
 )
 
+var sortOrderNames = map[string]SortOrder{
+	"relevance": SortRelevance,
+	"faves":     SortFaves,
+	"title":     SortTitle,
+	"newest":    SortNewest,
+}
+
+// ParseSortOrder converts the name of a sort order, e.g. "faves"
+func ParseSortOrder(name string) (SortOrder, error) {
+	if order, ok := sortOrderNames[strings.ToLower(name)]; ok {
+		return order, nil
+	}
+	return SortRelevance, fmt.Errorf("unknown sort order %q", name)
+}
+
+func (o SortOrder) fields() []string {
+	switch o {
+	case SortFaves:
+		return []string{"-" + favesField, "-_score"}
+	case SortTitle:
+		return []string{titleSortField, "-_score"}
+	case SortNewest:
+		return []string{"-" + addedField, "-_score"}
+	}
+	return []string{"-_score"}
+}
+
+// queryFields maps the qualifiers which can be used in a query, e.g.
+// "pepe author:voldy", to the fields they match
+var queryFields = map[string]string{
+	"title":    titleField,
+	"category": categoryField,
+	"desc":     descriptionField,
+	"author":   authorField,
+	"license":  licenseField,
+	"source":   sourceField,
+}
+
 type characterFilter struct {
 	lookupTable map[string]struct{}
 }
@@ -99,54 +181,453 @@ func (i *index) IndexEmoji(id string, e *Emoji) error {
 }
 
 func createDocFromEmoji(id string, e *Emoji) *bluge.Document {
-	return bluge.NewDocument(id).
-		AddField(bluge.NewTextField(titleField, e.Title)).
+	doc := bluge.NewDocument(id)
+	for _, alias := range e.Aliases {
+		doc.AddField(bluge.NewTextField(titleField, alias).SearchTermPositions()).
+			AddField(bluge.NewTextField(titleNGField, alias).WithAnalyzer(titleNgramAnalyzer))
+	}
+
+	return doc.
+		AddField(bluge.NewTextField(titleField, e.Title).SearchTermPositions()).
 		AddField(bluge.NewTextField(titleNGField, e.Title).WithAnalyzer(titleNgramAnalyzer)).
-		AddField(bluge.NewTextField(descriptionField, e.Description).WithAnalyzer(textAnalyzer)).
-		AddField(bluge.NewTextField(categoryField, e.Category).WithAnalyzer(textAnalyzer))
+		AddField(bluge.NewTextField(descriptionField, e.Description).WithAnalyzer(textAnalyzer).SearchTermPositions()).
+		AddField(bluge.NewTextField(categoryField, e.Category).WithAnalyzer(textAnalyzer).SearchTermPositions()).
+		AddField(bluge.NewTextField(keywordsField, strings.Join(e.Keywords, " ")).WithAnalyzer(textAnalyzer).SearchTermPositions()).
+		AddField(bluge.NewKeywordField(licenseField, strings.ToLower(e.License))).
+		AddField(bluge.NewTextField(authorField, e.Author).WithAnalyzer(textAnalyzer).SearchTermPositions()).
+		AddField(bluge.NewNumericField(favesField, float64(e.Faves)).StoreValue().Sortable()).
+		AddField(bluge.NewKeywordField(titleSortField, strings.ToLower(e.Title)).Sortable()).
+		AddField(bluge.NewNumericField(addedField, addedOrder(id)).Sortable()).
+		AddField(bluge.NewKeywordField(sourceField, strings.ToLower(e.Source)))
+}
+
+// addedOrder is used to sort by newest, emoji.gg ids increase as emojis
+// are submitted. Ids without a number are treated as the oldest.
+func addedOrder(id string) float64 {
+	n, err := strconv.Atoi(id[strings.LastIndex(id, ":")+1:])
+	if err != nil {
+		return 0
+	}
+	return float64(n)
 }
 
 func (i *index) IndexEmojiStore(store map[string]*Emoji) error {
-	batch := bluge.NewBatch()
+	w, err := i.Writer()
+	if err != nil {
+		return err
+	}
+
 	for id, e := range store {
-		doc := createDocFromEmoji(id, e)
-		batch.Update(doc.ID(), doc)
+		if err := w.Update(id, e); err != nil {
+			w.Close()
+			return err
+		}
 	}
+	return w.Close()
+}
+
+// indexWriter keeps the index open for writing and writes the changes in
+// batches of maxBatchSize
+type indexWriter struct {
+	w     *bluge.Writer
+	batch *blugeindex.Batch
+	size  int
+}
 
+// Writer opens the index to apply many changes, the writer must be closed
+// to write the last batch
+func (i *index) Writer() (*indexWriter, error) {
 	w, err := bluge.OpenWriter(i.cfg)
 	if err != nil {
-		return fmt.Errorf("unable to open writer: %w", err)
+		return nil, fmt.Errorf("unable to open writer: %w", err)
 	}
-	defer w.Close()
+	return &indexWriter{w: w, batch: bluge.NewBatch()}, nil
+}
 
-	err = w.Batch(batch)
-	if err != nil {
+// Update indexes the emoji, replacing the document with the same id
+func (iw *indexWriter) Update(id string, e *Emoji) error {
+	doc := createDocFromEmoji(id, e)
+	if e.VariantOf != "" {
+		// variants are found through the emoji they belong to
+		iw.batch.Delete(doc.ID())
+	} else {
+		iw.batch.Update(doc.ID(), doc)
+	}
+	return iw.added()
+}
+
+// Delete removes the emoji from the index
+func (iw *indexWriter) Delete(id string) error {
+	iw.batch.Delete(bluge.NewDocument(id).ID())
+	return iw.added()
+}
+
+func (iw *indexWriter) added() error {
+	iw.size++
+	if iw.size < maxBatchSize {
+		return nil
+	}
+	return iw.Flush()
+}
+
+// SetGeneration records the generation of the cache the index matches
+// with the next batch
+func (iw *indexWriter) SetGeneration(gen int64) error {
+	doc := bluge.NewDocument(metaDocID).
+		AddField(bluge.NewNumericField(generationField, float64(gen)).StoreValue()).
+		AddField(bluge.NewNumericField(schemaField, indexSchema).StoreValue())
+	iw.batch.Update(doc.ID(), doc)
+	return iw.added()
+}
+
+// Flush writes the pending changes to the index
+func (iw *indexWriter) Flush() error {
+	if iw.size == 0 {
+		return nil
+	}
+	if err := iw.w.Batch(iw.batch); err != nil {
 		return fmt.Errorf("unable to write batch update to index; %w", err)
 	}
+	iw.batch.Reset()
+	iw.size = 0
 	return nil
 }
 
-func (i *index) Delete(id string) error {
+// Close writes the pending changes and closes the index
+func (iw *indexWriter) Close() error {
+	err := iw.Flush()
+	if closeErr := iw.w.Close(); err == nil && closeErr != nil {
+		err = fmt.Errorf("unable to close writer: %w", closeErr)
+	}
+	return err
+}
+
+func (i *index) Delete(ids ...string) error {
 	w, err := bluge.OpenWriter(i.cfg)
 	if err != nil {
 		return fmt.Errorf("unable to open writer for delete: %w", err)
 	}
 	defer w.Close()
 
-	doc := bluge.NewDocument(id)
-	err = w.Delete(doc.ID())
+	batch := bluge.NewBatch()
+	for _, id := range ids {
+		batch.Delete(bluge.NewDocument(id).ID())
+	}
+
+	err = w.Batch(batch)
 	if err != nil {
-		return fmt.Errorf("unable to delete item %s: %w", id, err)
+		return fmt.Errorf("unable to delete items %v: %w", ids, err)
 	}
 	return nil
 }
 
-func (i *index) Search(text string) (*searchIter, error) {
+// IDs lists the ids of every document in the index
+func (i *index) IDs() ([]string, error) {
 	r, err := bluge.OpenReader(i.cfg)
 	if err != nil {
 		return nil, fmt.Errorf("unable to open index reader: %w", err)
 	}
+	defer r.Close()
+
+	iter, err := r.Search(context.Background(), bluge.NewAllMatches(bluge.NewMatchAllQuery()))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list documents: %w", err)
+	}
+
+	ids := []string{}
+	match, err := iter.Next()
+	for err == nil && match != nil {
+		err = match.VisitStoredFields(func(f string, value []byte) bool {
+			if f == "_id" {
+				if string(value) != metaDocID {
+					ids = append(ids, string(value))
+				}
+				return false
+			}
+			return true
+		})
+		if err == nil {
+			match, err = iter.Next()
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to list documents: %w", err)
+	}
+	return ids, nil
+}
 
+// searchOptions control how the results of a search are ordered
+type searchOptions struct {
+	sort SortOrder
+	// favesWeight scales the boost given to faved emojis when sorting
+	// by relevance, zero disables it
+	favesWeight float64
+	fuzzy       fuzziness
+	// limit is the most results returned, after skipping offset results
+	limit  int
+	offset int
+	// regexp searches titles with the text as a regular expression
+	// instead of parsing it as a query
+	regexp bool
+}
+
+func (i *index) Search(text string, opts searchOptions) (*searchIter, error) {
+	var query bluge.Query
+	var err error
+	if opts.regexp {
+		query, err = regexpQuery(text)
+	} else {
+		query, err = parseQuery(text, opts.fuzzy)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := bluge.OpenReader(i.cfg)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open index reader: %w", err)
+	}
+
+	if opts.sort == SortRelevance && opts.favesWeight > 0 {
+		// every match is ranked so the pages don't change order with
+		// the number of results fetched
+		defer r.Close()
+		iter, err := r.Search(context.Background(), bluge.NewAllMatches(query))
+		if err != nil {
+			return nil, fmt.Errorf("unable to perform search: %w", err)
+		}
+		return rankByFaves(iter, opts.favesWeight, opts.offset, opts.limit)
+	}
+
+	req := bluge.NewTopNSearch(opts.limit, query).
+		SetFrom(opts.offset).
+		SortBy(opts.sort.fields()).
+		WithStandardAggregations()
+
+	iter, err := r.Search(context.Background(), req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to perform search: %w", err)
+	}
+
+	return newSearchIter(iter, r), nil
+}
+
+type rankedMatch struct {
+	id    string
+	score float64
+}
+
+// rankByFaves reorders the matches after boosting their score by the log
+// of their faves, so popular emojis beat obscure duplicates. Only limit
+// matches after offset are kept.
+func rankByFaves(iter search.DocumentMatchIterator, weight float64, offset, limit int) (*searchIter, error) {
+	matches := []rankedMatch{}
+
+	match, err := iter.Next()
+	for err == nil && match != nil {
+		m := rankedMatch{score: match.Score}
+		faves := 0.0
+		err = match.VisitStoredFields(func(f string, value []byte) bool {
+			switch f {
+			case "_id":
+				m.id = string(value)
+			case favesField:
+				faves, _ = bluge.DecodeNumericFloat64(value)
+			}
+			return true
+		})
+		if err != nil {
+			break
+		}
+
+		m.score *= 1 + weight*math.Log1p(faves)
+		matches = append(matches, m)
+
+		match, err = iter.Next()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read search results: %w", err)
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return matches[i].score > matches[j].score
+	})
+
+	total := len(matches)
+	if offset > len(matches) {
+		offset = len(matches)
+	}
+	matches = matches[offset:]
+	if limit < len(matches) {
+		matches = matches[:limit]
+	}
+
+	ids := make([]string, len(matches))
+	for i, m := range matches {
+		ids[i] = m.id
+	}
+	return &searchIter{ranked: ids, total: total}, nil
+}
+
+// regexpQuery matches titles with the pattern, ignoring case. Patterns
+// aren't anchored unless they start with ^ or end with $, like in grep.
+func regexpQuery(pattern string) (bluge.Query, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl|syntax.FoldCase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression: %w", err)
+	}
+
+	// the index only matches whole terms, so the anchors are implied and
+	// unanchored ends have to match anything
+	anyChars := &syntax.Regexp{
+		Op:  syntax.OpStar,
+		Sub: []*syntax.Regexp{{Op: syntax.OpAnyChar}},
+	}
+	subs := []*syntax.Regexp{re}
+	if re.Op == syntax.OpConcat {
+		subs = re.Sub
+	}
+	if len(subs) > 0 && isBeginAnchor(subs[0]) {
+		subs = subs[1:]
+	} else {
+		subs = append([]*syntax.Regexp{anyChars}, subs...)
+	}
+	if len(subs) > 0 && isEndAnchor(subs[len(subs)-1]) {
+		subs = subs[:len(subs)-1]
+	} else {
+		subs = append(subs, anyChars)
+	}
+	re = &syntax.Regexp{Op: syntax.OpConcat, Sub: subs}
+
+	// bluge only reports unsupported patterns when searching
+	if _, err := vellumregexp.New(re.String()); err != nil {
+		return nil, fmt.Errorf("unsupported regular expression %q: %w", pattern, err)
+	}
+	return bluge.NewRegexpQuery(re.String()).SetField(titleSortField), nil
+}
+
+func isBeginAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpBeginText || re.Op == syntax.OpBeginLine
+}
+
+func isEndAnchor(re *syntax.Regexp) bool {
+	return re.Op == syntax.OpEndText || re.Op == syntax.OpEndLine
+}
+
+// parseQuery converts the query syntax, see the query package, to a
+// bluge query
+func parseQuery(text string, fuzzy fuzziness) (bluge.Query, error) {
+	fields := make([]string, 0, len(queryFields))
+	for qualifier := range queryFields {
+		fields = append(fields, qualifier)
+	}
+
+	n, err := query.Parse(text, fields)
+	if err != nil {
+		return nil, err
+	}
+	return compileQuery(n, fuzzy), nil
+}
+
+func compileQuery(n query.Node, fuzzy fuzziness) bluge.Query {
+	switch n := n.(type) {
+	case query.Term:
+		return termQuery(n, fuzzy)
+	case query.Not:
+		// excluding needs something to exclude from
+		return bluge.NewBooleanQuery().
+			AddMust(allEmojisQuery()).
+			AddMustNot(compileQuery(n.Node, fuzzy))
+	case query.And:
+		q := bluge.NewBooleanQuery()
+		included := false
+		for _, c := range n.Nodes {
+			if not, ok := c.(query.Not); ok {
+				q.AddMustNot(compileQuery(not.Node, fuzzy))
+				continue
+			}
+			q.AddMust(compileQuery(c, fuzzy))
+			included = true
+		}
+		if !included {
+			q.AddMust(allEmojisQuery())
+		}
+		return q
+	case query.Or:
+		q := bluge.NewBooleanQuery().SetMinShould(1)
+		for _, c := range n.Nodes {
+			q.AddShould(compileQuery(c, fuzzy))
+		}
+		return q
+	}
+	return bluge.NewMatchNoneQuery()
+}
+
+// allEmojisQuery matches every emoji, which the excluded terms of a query
+// are removed from
+func allEmojisQuery() bluge.Query {
+	return bluge.NewBooleanQuery().
+		AddMust(bluge.NewMatchAllQuery()).
+		AddMustNot(bluge.NewTermQuery(metaDocID).SetField("_id"))
+}
+
+// termQuery matches the text of a term in its field, unqualified terms are
+// matched against the title, category, description and keywords
+func termQuery(t query.Term, fuzzy fuzziness) bluge.Query {
+	field, ok := queryFields[t.Field]
+	if t.Glob && (!ok || field == titleField) {
+		// globs match the whole title like in a shell, `*hug*` finds
+		// titles with hug anywhere. the sort field is lowercase so
+		// lowering the glob makes it case insensitive
+		return bluge.NewWildcardQuery(strings.ToLower(t.Text)).
+			SetField(titleSortField).
+			SetBoost(5)
+	}
+	if t.Glob {
+		// other fields are matched a word at a time, their words and
+		// the keyword fields are lowercase too
+		return bluge.NewWildcardQuery(strings.ToLower(t.Text)).SetField(field)
+	}
+	if !ok {
+		if t.Phrase {
+			return bluge.NewBooleanQuery().SetMinShould(1).AddShould(
+				bluge.NewMatchPhraseQuery(t.Text).SetField(titleField).SetBoost(5),
+				bluge.NewMatchPhraseQuery(t.Text).SetField(categoryField).SetAnalyzer(textAnalyzer),
+				bluge.NewMatchPhraseQuery(t.Text).SetField(descriptionField).SetAnalyzer(textAnalyzer),
+				bluge.NewMatchPhraseQuery(t.Text).SetField(keywordsField).SetAnalyzer(textAnalyzer).SetBoost(2),
+			)
+		}
+		return textQuery(t.Text, fuzzy)
+	}
+
+	switch {
+	case field == licenseField || field == sourceField:
+		return bluge.NewTermQuery(strings.ToLower(t.Text)).SetField(field)
+	case t.Phrase && field == titleField:
+		return bluge.NewMatchPhraseQuery(t.Text).SetField(titleField)
+	case t.Phrase:
+		return bluge.NewMatchPhraseQuery(t.Text).SetField(field).SetAnalyzer(textAnalyzer)
+	case field == titleField:
+		q := bluge.NewBooleanQuery().SetMinShould(1).AddShould(
+			bluge.NewMatchQuery(t.Text).SetField(titleField).
+				SetOperator(bluge.MatchQueryOperatorAnd),
+			bluge.NewMatchQuery(t.Text).SetField(titleNGField).
+				SetAnalyzer(titleNgramAnalyzer).
+				SetOperator(bluge.MatchQueryOperatorAnd),
+		)
+		if fq := fuzzy.query(t.Text); fq != nil {
+			q.AddShould(fq)
+		}
+		return q
+	}
+	return bluge.NewMatchQuery(t.Text).SetField(field).
+		SetAnalyzer(textAnalyzer).
+		SetOperator(bluge.MatchQueryOperatorAnd)
+}
+
+// textQuery is the default search, matching text anywhere and preferring
+// matches in the title
+func textQuery(text string, fuzzy fuzziness) bluge.Query {
 	titlePrefixQuery := bluge.NewPrefixQuery(text).SetField(titleField)
 
 	titleQuery := bluge.NewMatchQuery(text).
@@ -161,22 +642,67 @@ func (i *index) Search(text string) (*searchIter, error) {
 	descQuery := bluge.NewMatchQuery(text).SetField(descriptionField).
 		SetAnalyzer(textAnalyzer)
 
-	query := bluge.NewBooleanQuery().AddShould(
+	keywordsQuery := bluge.NewMatchQuery(text).SetField(keywordsField).
+		SetAnalyzer(textAnalyzer).
+		SetBoost(2)
+
+	q := bluge.NewBooleanQuery().SetMinShould(1).AddShould(
 		titlePrefixQuery, titleQuery,
 		categoryQuery,
 		descQuery,
+		keywordsQuery,
 	)
+	if fq := fuzzy.query(text); fq != nil {
+		q.AddShould(fq)
+	}
+	return q
+}
 
-	req := bluge.NewTopNSearch(50, query).WithStandardAggregations()
+// fuzziness is how many typos are tolerated in titles, see WithFuzzy
+type fuzziness struct {
+	distance int
+	prefix   int
+}
 
-	iter, err := r.Search(context.Background(), req)
-	if err != nil {
-		return nil, fmt.Errorf("unable to perform search: %w", err)
+// query matches titles within the edit distance of the words in text, it's
+// boosted less than exact and prefix matches. It's nil when no word is long
+// enough to have typos.
+func (f fuzziness) query(text string) bluge.Query {
+	q := bluge.NewBooleanQuery().SetBoost(0.5)
+	fuzzy := false
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		distance := f.distanceFor(word)
+		if distance == 0 {
+			continue
+		}
+		q.AddShould(bluge.NewFuzzyQuery(word).
+			SetField(titleSortField).
+			SetFuzziness(distance).
+			SetPrefix(f.prefix))
+		fuzzy = true
+	}
+	if !fuzzy {
+		return nil
 	}
+	return q
+}
 
-	return newSearchIter(iter, r), nil
+// distanceFor allows fewer edits in short words, which would otherwise
+// match most titles of their length
+func (f fuzziness) distanceFor(word string) int {
+	n := utf8.RuneCountInString(word)
+	switch {
+	case n < 3 || f.distance < 0:
+		return 0
+	case n < 6 && f.distance > 1:
+		return 1
+	case f.distance > maxFuzzyDistance:
+		return maxFuzzyDistance
+	}
+	return f.distance
 }
 
+// Count is the number of emojis in the index
 func (i *index) Count() int {
 	r, err := bluge.OpenReader(i.cfg)
 	if err != nil {
@@ -188,15 +714,72 @@ func (i *index) Count() int {
 	if err != nil {
 		return 0
 	}
+	if _, _, ok, _ := readMeta(r); ok {
+		count--
+	}
 
 	return int(count)
 }
 
+// Generation is the generation of the cache the index was built from, ok
+// is false for indexes without one or with an older schema, both have to
+// be rebuilt
+func (i *index) Generation() (gen int64, ok bool, err error) {
+	r, err := bluge.OpenReader(i.cfg)
+	if err != nil {
+		return 0, false, fmt.Errorf("unable to open index reader: %w", err)
+	}
+	defer r.Close()
+
+	gen, schema, ok, err := readMeta(r)
+	return gen, ok && schema == indexSchema, err
+}
+
+// readMeta reads the generation and schema of the index, ok is false when
+// there is no meta document
+func readMeta(r *bluge.Reader) (gen int64, schema int, ok bool, err error) {
+	q := bluge.NewTermQuery(metaDocID).SetField("_id")
+	iter, err := r.Search(context.Background(), bluge.NewTopNSearch(1, q))
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("unable to read index generation: %w", err)
+	}
+
+	match, err := iter.Next()
+	if err != nil || match == nil {
+		return 0, 0, false, err
+	}
+	// indexes from before the schema was recorded are version 1
+	schema = 1
+	var decodeErr error
+	err = match.VisitStoredFields(func(f string, value []byte) bool {
+		var n float64
+		switch f {
+		case generationField:
+			n, decodeErr = bluge.DecodeNumericFloat64(value)
+			gen, ok = int64(n), decodeErr == nil
+		case schemaField:
+			n, decodeErr = bluge.DecodeNumericFloat64(value)
+			schema = int(n)
+		}
+		return decodeErr == nil
+	})
+	if err == nil {
+		err = decodeErr
+	}
+	return gen, schema, ok, err
+}
+
 type searchIter struct {
 	docIter   search.DocumentMatchIterator
 	reader    *bluge.Reader
 	lastError error
 	match     *search.DocumentMatch
+	// ranked holds the remaining ids when results were reordered
+	// after searching, docIter is not used then
+	ranked []string
+	// total is the number of matches, including those which weren't
+	// returned because of the limit and offset
+	total int
 }
 
 func newSearchIter(iter search.DocumentMatchIterator, r *bluge.Reader) *searchIter {
@@ -205,6 +788,7 @@ func newSearchIter(iter search.DocumentMatchIterator, r *bluge.Reader) *searchIt
 		reader:    r,
 		lastError: nil,
 		match:     nil,
+		total:     int(iter.Aggregations().Count()),
 	}
 }
 func (s *searchIter) Next() (string, error) {
@@ -218,6 +802,16 @@ func (s *searchIter) Next() (string, error) {
 		return "", s.lastError
 	}
 
+	if s.docIter == nil {
+		if len(s.ranked) == 0 {
+			s.lastError = fmt.Errorf("no more results")
+			return "", s.lastError
+		}
+		id := s.ranked[0]
+		s.ranked = s.ranked[1:]
+		return id, nil
+	}
+
 	s.match, s.lastError = s.docIter.Next()
 	if s.lastError != nil {
 		return "", s.lastError
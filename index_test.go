@@ -0,0 +1,195 @@
+package emos
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSearchQualifiedGlobs(t *testing.T) {
+	idx := testIndex(t, map[string]*Emoji{
+		"emojigg:1": {Title: "pepehug", Category: "Anime", Author: "voldyman", License: "CC-BY", Source: "emojigg"},
+		"slack:2":   {Title: "blobhug", Category: "Blobs", Source: "slack"},
+	})
+
+	for query, expected := range map[string]string{
+		"category:ani*":  "emojigg:1",
+		"category:BLOB?": "slack:2",
+		"author:vold*":   "emojigg:1",
+		"license:cc-*":   "emojigg:1",
+		"source:sl*":     "slack:2",
+	} {
+		ids := searchIDs(t, idx, query, searchOptions{})
+		if len(ids) != 1 || ids[0] != expected {
+			t.Fatalf("expected %s to find %s, got: %v", query, expected, ids)
+		}
+	}
+}
+
+func searchIDs(t *testing.T, idx *index, query string, opts searchOptions) []string {
+	t.Helper()
+	if opts.limit == 0 {
+		opts.limit = DefaultLimit
+	}
+	iter, err := idx.Search(query, opts)
+	if err != nil {
+		t.Fatalf("unable to search %q: %v", query, err)
+	}
+	ids := []string{}
+	for id, err := iter.Next(); err == nil; id, err = iter.Next() {
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+func testIndex(t *testing.T, store map[string]*Emoji) *index {
+	t.Helper()
+	idx, err := NewIndex(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.IndexEmojiStore(store); err != nil {
+		t.Fatal(err)
+	}
+	return idx
+}
+
+func TestSearchRegexp(t *testing.T) {
+	idx := testIndex(t, map[string]*Emoji{
+		"1": {Title: "PepeHug"},
+		"2": {Title: "pepelove"},
+		"3": {Title: "pepe_hugs"},
+		"4": {Title: "blobhug"},
+	})
+
+	tests := []struct {
+		pattern  string
+		expected []string
+	}{
+		{"^pepe.*(hug|love)$", []string{"1", "2"}},
+		{"hug$", []string{"1", "4"}},
+		{"^pepe", []string{"1", "2", "3"}},
+		{"HUG", []string{"1", "3", "4"}},
+	}
+	for _, test := range tests {
+		ids := searchIDs(t, idx, test.pattern, searchOptions{regexp: true})
+		sort.Strings(ids)
+		if !reflect.DeepEqual(ids, test.expected) {
+			t.Fatalf("expected %s to find %v, got: %v", test.pattern, test.expected, ids)
+		}
+	}
+
+	for _, pattern := range []string{`\bpepe`, "^a|b$", "(pepe"} {
+		if _, err := idx.Search(pattern, searchOptions{regexp: true, limit: DefaultLimit}); err == nil {
+			t.Fatalf("expected %s to be rejected", pattern)
+		}
+	}
+}
+
+func TestFuzzinessDistance(t *testing.T) {
+	f := fuzziness{distance: 2, prefix: 1}
+	for word, expected := range map[string]int{
+		"hi":       0,
+		"cry":      1,
+		"pepe":     1,
+		"monkahm":  2,
+		"pepehgu":  2,
+		"ñandú":    1,
+		"blobhugs": 2,
+	} {
+		if d := f.distanceFor(word); d != expected {
+			t.Fatalf("expected %d edits for %s but got %d", expected, word, d)
+		}
+	}
+
+	if d := (fuzziness{distance: 5}).distanceFor("monkahm"); d != maxFuzzyDistance {
+		t.Fatalf("expected the distance to be capped at %d, got %d", maxFuzzyDistance, d)
+	}
+	if d := (fuzziness{distance: 1}).distanceFor("monkahm"); d != 1 {
+		t.Fatalf("expected the configured distance, got %d", d)
+	}
+}
+
+func TestSearchFuzzy(t *testing.T) {
+	idx := testIndex(t, map[string]*Emoji{
+		"1": {Title: "monkaHmm"},
+		"2": {Title: "pepehug"},
+		"3": {Title: "cry"},
+	})
+
+	fuzzy := fuzziness{distance: 2, prefix: 1}
+	for query, expected := range map[string][]string{
+		"title:monkahm": {"1"},
+		"title:pepehgu": {"2"},
+		"title:cri":     {"3"},
+		// the first letter has to be right
+		"title:xepehug": {},
+		// two letters are too short for typos
+		"title:cr": {},
+	} {
+		ids := searchIDs(t, idx, query, searchOptions{fuzzy: fuzzy})
+		if !reflect.DeepEqual(ids, expected) {
+			t.Fatalf("expected %s to find %v, got: %v", query, expected, ids)
+		}
+	}
+
+	// a distance of zero turns it off
+	if ids := searchIDs(t, idx, "title:pepehgu", searchOptions{}); len(ids) != 0 {
+		t.Fatalf("expected no typos to be tolerated, got: %v", ids)
+	}
+}
+
+func TestSearchPages(t *testing.T) {
+	store := map[string]*Emoji{}
+	for i := 0; i < 120; i++ {
+		id := fmt.Sprintf("%03d", i)
+		store[id] = &Emoji{Title: "blob" + id, Faves: i}
+	}
+	idx := testIndex(t, store)
+
+	for name, opts := range map[string]searchOptions{
+		"relevance": {favesWeight: DefaultFavesWeight},
+		"title":     {sort: SortTitle},
+	} {
+		seen := map[string]bool{}
+		for offset := 0; offset < 120; offset += 50 {
+			opts.limit, opts.offset = 50, offset
+			iter, err := idx.Search("blob", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if iter.total != 120 {
+				t.Fatalf("expected 120 matches sorting by %s, got %d", name, iter.total)
+			}
+
+			page := 0
+			for id, err := iter.Next(); err == nil; id, err = iter.Next() {
+				if seen[id] {
+					t.Fatalf("%s was on two pages sorting by %s", id, name)
+				}
+				seen[id] = true
+				page++
+			}
+			expected := 50
+			if 120-offset < expected {
+				expected = 120 - offset
+			}
+			if page != expected {
+				t.Fatalf("expected %d results at offset %d sorting by %s, got %d", expected, offset, name, page)
+			}
+		}
+		if len(seen) != 120 {
+			t.Fatalf("expected every match on a page sorting by %s, got %d", name, len(seen))
+		}
+	}
+
+	// the most faved first, then the next page
+	ids := searchIDs(t, idx, "blob", searchOptions{favesWeight: DefaultFavesWeight, limit: 2, offset: 2})
+	if !reflect.DeepEqual(ids, []string{"117", "116"}) {
+		t.Fatalf("unexpected second page: %v", ids)
+	}
+	if ids := searchIDs(t, idx, "blob", searchOptions{sort: SortTitle, limit: 5, offset: 500}); len(ids) != 0 {
+		t.Fatalf("expected no results past the end, got: %v", ids)
+	}
+}
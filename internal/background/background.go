@@ -0,0 +1,36 @@
+// Package background starts processes which outlive the one starting them
+package background
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// Start runs the current executable with args, detached from the current
+// process and terminal. Its output is appended to logPath, it's discarded
+// when logPath is empty.
+func Start(logPath string, args ...string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		exe = os.Args[0]
+	}
+
+	cmd := exec.Command(exe, args...)
+	detach(cmd)
+
+	if logPath != "" {
+		log, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to open log for background process: %w", err)
+		}
+		defer log.Close()
+		cmd.Stdout, cmd.Stderr = log, log
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("unable to start background process: %w", err)
+	}
+	// the process is not waited for, releasing it keeps no zombie around
+	return cmd.Process.Release()
+}
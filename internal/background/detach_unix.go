@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package background
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detach starts the process in its own session, so it keeps running when
+// the terminal of the parent is closed
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+}
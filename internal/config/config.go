@@ -13,6 +13,8 @@ const (
 	IndexFileName = "emos.index"
 	// ImageCacheDir is used to cache emoji images
 	ImageCacheDir = "imgs"
+	// UpdateLogFileName is used for the output of background updates
+	UpdateLogFileName = "update.log"
 )
 
 // Loc returns the expected location of the config file
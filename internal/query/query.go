@@ -0,0 +1,339 @@
+// Package query parses the search syntax of emos, e.g.
+// `pepe category:anime -blob` or `"sad cat" OR title:cry*`
+package query
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Node is a part of a parsed query
+type Node interface {
+	node()
+}
+
+// Term matches Text, in Field when it was qualified. Consecutive words
+// without operators between them are kept together as one term, so they
+// are ranked together as before the query syntax existed.
+type Term struct {
+	// Field is the lowercase qualifier, empty for the default fields
+	Field string
+	Text  string
+	// Phrase is true for quoted text, whose words have to be in order
+	Phrase bool
+	// Glob is true for words with the wildcards * or ?, which are matched
+	// against whole titles and never merged with other words
+	Glob bool
+}
+
+// Not excludes what Node matches
+type Not struct {
+	Node Node
+}
+
+// And matches what all of Nodes match
+type And struct {
+	Nodes []Node
+}
+
+// Or matches what any of Nodes match
+type Or struct {
+	Nodes []Node
+}
+
+func (Term) node() {}
+func (Not) node()  {}
+func (And) node()  {}
+func (Or) node()   {}
+
+// SyntaxError describes why a query couldn't be parsed
+type SyntaxError struct {
+	// Pos is the byte offset in the query where the error was found
+	Pos int
+	Msg string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("invalid query at position %d: %s", e.Pos+1, e.Msg)
+}
+
+// Parse parses the query, qualifiers are only recognized for fields, e.g.
+// "title", other words with a colon are searched as they are
+func Parse(input string, fields []string) (Node, error) {
+	known := map[string]bool{}
+	for _, f := range fields {
+		known[strings.ToLower(f)] = true
+	}
+
+	tokens, err := lex(input, known)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, &SyntaxError{Pos: 0, Msg: "nothing to search for"}
+	}
+
+	p := &parser{tokens: tokens, end: len(input)}
+	n, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t != nil {
+		return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+	}
+	return n, nil
+}
+
+type tokenKind int
+
+const (
+	wordToken tokenKind = iota
+	phraseToken
+	andToken
+	orToken
+	notToken
+	openToken
+	closeToken
+)
+
+type token struct {
+	kind  tokenKind
+	pos   int
+	field string
+	text  string
+}
+
+func (t *token) String() string {
+	switch t.kind {
+	case andToken:
+		return "AND"
+	case orToken:
+		return "OR"
+	case notToken:
+		return `"-"`
+	case openToken:
+		return `"("`
+	case closeToken:
+		return `")"`
+	}
+	return fmt.Sprintf("%q", t.text)
+}
+
+func lex(input string, fields map[string]bool) ([]*token, error) {
+	var tokens []*token
+	field, fieldPos := "", 0
+
+	for i := 0; i < len(input); {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n':
+			i++
+			continue
+
+		case c == '(' || c == ')':
+			kind := openToken
+			if c == ')' {
+				kind = closeToken
+			}
+			tokens = append(tokens, &token{kind: kind, pos: i})
+			i++
+
+		case c == '"':
+			end := strings.IndexByte(input[i+1:], '"')
+			if end < 0 {
+				return nil, &SyntaxError{Pos: i, Msg: "missing closing quote"}
+			}
+			text := strings.TrimSpace(input[i+1 : i+1+end])
+			if text == "" {
+				return nil, &SyntaxError{Pos: i, Msg: "empty phrase"}
+			}
+			pos := i
+			if field != "" {
+				pos = fieldPos
+			}
+			tokens = append(tokens, &token{kind: phraseToken, pos: pos, field: field, text: text})
+			field = ""
+			i += end + 2
+			continue
+
+		case c == '-' && i+1 < len(input) && !strings.ContainsRune(" \t\n)", rune(input[i+1])):
+			tokens = append(tokens, &token{kind: notToken, pos: i})
+			i++
+
+		default:
+			end := i
+			for end < len(input) && !strings.ContainsRune(" \t\n()\"", rune(input[end])) {
+				end++
+			}
+			word := input[i:end]
+
+			if colon := strings.IndexByte(word, ':'); colon > 0 && fields[strings.ToLower(word[:colon])] {
+				name, value := strings.ToLower(word[:colon]), word[colon+1:]
+				if value == "" {
+					if end < len(input) && input[end] == '"' {
+						// the phrase which follows is qualified
+						field, fieldPos = name, i
+						i = end
+						continue
+					}
+					return nil, &SyntaxError{Pos: i, Msg: fmt.Sprintf("missing value after %q", word)}
+				}
+				tokens = append(tokens, &token{kind: wordToken, pos: i, field: name, text: value})
+			} else if word == "AND" {
+				tokens = append(tokens, &token{kind: andToken, pos: i})
+			} else if word == "OR" {
+				tokens = append(tokens, &token{kind: orToken, pos: i})
+			} else {
+				tokens = append(tokens, &token{kind: wordToken, pos: i, text: word})
+			}
+			i = end
+		}
+		field = ""
+	}
+	return tokens, nil
+}
+
+type parser struct {
+	tokens []*token
+	next   int
+	// end is the length of the input, where errors at the end are placed
+	end int
+}
+
+func (p *parser) peek() *token {
+	if p.next < len(p.tokens) {
+		return p.tokens[p.next]
+	}
+	return nil
+}
+
+func (p *parser) pos() int {
+	if t := p.peek(); t != nil {
+		return t.pos
+	}
+	return p.end
+}
+
+// or parses `and (OR and)*`
+func (p *parser) or() (Node, error) {
+	n, err := p.and()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := []Node{n}
+	for t := p.peek(); t != nil && t.kind == orToken; t = p.peek() {
+		p.next++
+		if next := p.peek(); next == nil || next.kind == closeToken {
+			return nil, &SyntaxError{Pos: p.pos(), Msg: "OR needs a term after it"}
+		}
+		n, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+
+	if len(nodes) == 1 {
+		return nodes[0], nil
+	}
+	return Or{Nodes: nodes}, nil
+}
+
+// and parses `unary ([AND] unary)*`, words next to each other without an
+// operator are merged into one term
+func (p *parser) and() (Node, error) {
+	var nodes []Node
+	explicit := false
+	for {
+		t := p.peek()
+		if t == nil || t.kind == orToken || t.kind == closeToken {
+			break
+		}
+		if t.kind == andToken {
+			if len(nodes) == 0 {
+				return nil, &SyntaxError{Pos: t.pos, Msg: "AND needs a term before it"}
+			}
+			p.next++
+			explicit = true
+			continue
+		}
+
+		n, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		if term, ok := n.(Term); ok && !explicit && len(nodes) > 0 && isPlainWord(term) {
+			if prev, ok := nodes[len(nodes)-1].(Term); ok && isPlainWord(prev) {
+				prev.Text += " " + term.Text
+				nodes[len(nodes)-1] = prev
+				continue
+			}
+		}
+		nodes = append(nodes, n)
+		explicit = false
+	}
+
+	if explicit {
+		return nil, &SyntaxError{Pos: p.pos(), Msg: "AND needs a term after it"}
+	}
+	switch len(nodes) {
+	case 0:
+		if t := p.peek(); t != nil && t.kind == orToken {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "OR needs a term before it"}
+		}
+		return nil, &SyntaxError{Pos: p.pos(), Msg: "expected a term"}
+	case 1:
+		return nodes[0], nil
+	}
+	return And{Nodes: nodes}, nil
+}
+
+func isPlainWord(t Term) bool {
+	return t.Field == "" && !t.Phrase && !t.Glob
+}
+
+func isGlob(word string) bool {
+	return strings.ContainsAny(word, "*?")
+}
+
+// unary parses `[-] primary`
+func (p *parser) unary() (Node, error) {
+	if t := p.peek(); t != nil && t.kind == notToken {
+		p.next++
+		n, err := p.primary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{Node: n}, nil
+	}
+	return p.primary()
+}
+
+// primary parses a term, a phrase or `( or )`
+func (p *parser) primary() (Node, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, &SyntaxError{Pos: p.end, Msg: "expected a term"}
+	}
+	p.next++
+
+	switch t.kind {
+	case wordToken:
+		return Term{Field: t.field, Text: t.text, Glob: isGlob(t.text)}, nil
+	case phraseToken:
+		return Term{Field: t.field, Text: t.text, Phrase: true}, nil
+	case openToken:
+		n, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if c := p.peek(); c == nil || c.kind != closeToken {
+			return nil, &SyntaxError{Pos: t.pos, Msg: "missing closing parenthesis"}
+		}
+		p.next++
+		return n, nil
+	}
+	return nil, &SyntaxError{Pos: t.pos, Msg: fmt.Sprintf("unexpected %s", t)}
+}
@@ -0,0 +1,76 @@
+package query
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testFields = []string{"title", "category", "desc", "author"}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected Node
+	}{
+		{"sad cat", Term{Text: "sad cat"}},
+		{":blobsweat:", Term{Text: ":blobsweat:"}},
+		{"pepe Category:Anime -blob", And{Nodes: []Node{
+			Term{Text: "pepe"},
+			Term{Field: "category", Text: "Anime"},
+			Not{Node: Term{Text: "blob"}},
+		}}},
+		{`desc:"sad frog" OR title:cry`, Or{Nodes: []Node{
+			Term{Field: "desc", Text: "sad frog", Phrase: true},
+			Term{Field: "title", Text: "cry"},
+		}}},
+		{"monka* pepe *hug? title:cry*", And{Nodes: []Node{
+			Term{Text: "monka*", Glob: true},
+			Term{Text: "pepe"},
+			Term{Text: "*hug?", Glob: true},
+			Term{Field: "title", Text: "cry*", Glob: true},
+		}}},
+		{"pepe AND hug", And{Nodes: []Node{Term{Text: "pepe"}, Term{Text: "hug"}}}},
+		{"(pepe OR blob) -(author:voldy)", And{Nodes: []Node{
+			Or{Nodes: []Node{Term{Text: "pepe"}, Term{Text: "blob"}}},
+			Not{Node: Term{Field: "author", Text: "voldy"}},
+		}}},
+	}
+
+	for _, test := range tests {
+		n, err := Parse(test.input, testFields)
+		if err != nil {
+			t.Fatalf("unable to parse %q: %v", test.input, err)
+		}
+		if !reflect.DeepEqual(n, test.expected) {
+			t.Fatalf("%q parsed as %#v, expected %#v", test.input, n, test.expected)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		input string
+		pos   int
+	}{
+		{`"sad cat`, 0},
+		{"title:", 0},
+		{"pepe AND", 8},
+		{"OR pepe", 0},
+		{"pepe OR", 7},
+		{"(pepe", 0},
+		{"pepe)", 4},
+		{"", 0},
+	}
+
+	for _, test := range tests {
+		_, err := Parse(test.input, testFields)
+		var syntaxErr *SyntaxError
+		if !errors.As(err, &syntaxErr) {
+			t.Fatalf("expected a syntax error for %q, got: %v", test.input, err)
+		}
+		if syntaxErr.Pos != test.pos {
+			t.Fatalf("expected the error for %q at %d, got: %v", test.input, test.pos, err)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package webclient
+
+import (
+	"net/http"
+	"time"
+)
+
+// DefaultUserAgent is sent with requests when no other is configured
+const DefaultUserAgent = "emos (+https://github.com/voldyman/emos)"
+
+// Do sends the request with the user agent set and the timeout applied to
+// the client, defaults are used for empty values
+func Do(client *http.Client, req *http.Request, userAgent string, timeout time.Duration) (*http.Response, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if timeout > 0 {
+		c := *client
+		c.Timeout = timeout
+		client = &c
+	}
+
+	if userAgent == "" {
+		userAgent = DefaultUserAgent
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	return client.Do(req)
+}
@@ -0,0 +1,53 @@
+package emos
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// ErrUpdateInProgress is returned when another process is already
+// updating the emojis
+var ErrUpdateInProgress = errors.New("emojis are already being updated")
+
+// staleLockAge is how old a lock can get before it's considered to be
+// left behind by an update which crashed
+const staleLockAge = 15 * time.Minute
+
+// lockLoc is the lock file held while the catalog at cacheLoc is updated
+func lockLoc(cacheLoc string) string {
+	return cacheLoc + ".lock"
+}
+
+// acquireLock creates the lock file, the returned func removes it again.
+// ErrUpdateInProgress is returned when the lock is held.
+func acquireLock(loc string) (func(), error) {
+	f, err := os.OpenFile(loc, os.O_CREATE|os.O_EXCL|os.O_WRONLY, writePerms)
+	if os.IsExist(err) && isStaleLock(loc) {
+		os.Remove(loc)
+		f, err = os.OpenFile(loc, os.O_CREATE|os.O_EXCL|os.O_WRONLY, writePerms)
+	}
+	if os.IsExist(err) {
+		return nil, ErrUpdateInProgress
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to create lock file: %w", err)
+	}
+
+	fmt.Fprintln(f, os.Getpid())
+	f.Close()
+	return func() { os.Remove(loc) }, nil
+}
+
+func isStaleLock(loc string) bool {
+	info, err := os.Stat(loc)
+	return err == nil && time.Since(info.ModTime()) > staleLockAge
+}
+
+// UpdateInProgress reports whether emojis are being updated, possibly by
+// another process
+func (es *EmojiSearch) UpdateInProgress() bool {
+	_, err := os.Stat(lockLoc(es.emojiCacheLoc))
+	return err == nil && !isStaleLock(lockLoc(es.emojiCacheLoc))
+}
@@ -0,0 +1,35 @@
+package emos
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAcquireLock(t *testing.T) {
+	loc := filepath.Join(t.TempDir(), "emoji.json.lock")
+
+	release, err := acquireLock(loc)
+	if err != nil {
+		t.Fatal("unable to acquire lock:", err)
+	}
+	if _, err := acquireLock(loc); !errors.Is(err, ErrUpdateInProgress) {
+		t.Fatalf("expected the lock to be held, got: %v", err)
+	}
+
+	// left behind by a crashed update
+	old := time.Now().Add(-2 * staleLockAge)
+	if err := os.Chtimes(loc, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acquireLock(loc); err != nil {
+		t.Fatal("expected a stale lock to be taken over, got:", err)
+	}
+
+	release()
+	if _, err := os.Stat(loc); !os.IsNotExist(err) {
+		t.Fatal("expected the lock to be removed, got:", err)
+	}
+}
@@ -0,0 +1,269 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Provider supplies a catalog of emojis to EmojiSearch
+type Provider interface {
+	// Name is a human readable name of the emoji source
+	Name() string
+	// IDPrefix is a stable, short identifier for the source, used to
+	// namespace the ids of its emojis
+	IDPrefix() string
+	// Fetch returns the full catalog of the source keyed by emoji id. A
+	// *PartialError can be returned along with a usable catalog.
+	Fetch(ctx context.Context) (map[string]*Emoji, error)
+}
+
+// ErrNotModified is returned by providers when their catalog hasn't
+// changed since it was last fetched
+var ErrNotModified = errors.New("catalog not modified")
+
+// Validators are the http cache validators of a response
+type Validators struct {
+	ETag         string `json:",omitempty"`
+	LastModified string `json:",omitempty"`
+}
+
+// ConditionalProvider is a Provider which can skip downloading a catalog
+// that hasn't changed, its Fetch returns ErrNotModified then
+type ConditionalProvider interface {
+	Provider
+	// Validators returns the validators of the last fetch keyed by url
+	Validators() map[string]Validators
+	// SetValidators sets the validators sent with the next fetch
+	SetValidators(map[string]Validators)
+}
+
+// CachedProvider is a Provider which falls back to its cached emojis for
+// the parts of its catalog it can't fetch
+type CachedProvider interface {
+	Provider
+	// SetCached sets the emojis of the provider from the cache, keyed
+	// like Fetch
+	SetCached(map[string]*Emoji)
+}
+
+// StreamingProvider is a Provider which can pass on its emojis while the
+// catalog is downloaded, so they can be indexed before it is complete
+type StreamingProvider interface {
+	Provider
+	// Stream calls fn with every emoji of the catalog, keyed like Fetch.
+	// It returns the errors Fetch would, an error from fn stops it.
+	Stream(ctx context.Context, fn func(id string, e *Emoji) error) error
+}
+
+// RemoteProvider is a Provider which fetches its catalog over the network
+type RemoteProvider interface {
+	Provider
+	// Remote reports whether fetching needs the network
+	Remote() bool
+}
+
+// ErrNoLocalCatalog is returned in offline mode when there are no cached
+// emojis, they have to be fetched while online first
+var ErrNoLocalCatalog = errors.New("no local emoji catalog, update the emojis while online first")
+
+// setCachedEmojis gives the cached providers their emojis from the store
+func setCachedEmojis(providers []Provider, store map[string]*Emoji) {
+	for _, p := range providers {
+		cp, ok := p.(CachedProvider)
+		if !ok {
+			continue
+		}
+
+		prefix := p.IDPrefix()
+		cached := map[string]*Emoji{}
+		for id, e := range store {
+			if e.Source == prefix {
+				cached[strings.TrimPrefix(id, prefix+":")] = e
+			}
+		}
+		cp.SetCached(cached)
+	}
+}
+
+// skippedProvider stands in for a remote provider in offline mode, its
+// cached emojis are kept as if the catalog wasn't modified
+type skippedProvider struct {
+	Provider
+}
+
+func (p skippedProvider) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	return nil, ErrNotModified
+}
+
+// reachableProviders replaces the remote providers with skipped ones when
+// offline
+func reachableProviders(providers []Provider, offline bool) []Provider {
+	if !offline {
+		return providers
+	}
+
+	result := make([]Provider, 0, len(providers))
+	for _, p := range providers {
+		if rp, ok := p.(RemoteProvider); ok && rp.Remote() {
+			p = skippedProvider{p}
+		}
+		result = append(result, p)
+	}
+	return result
+}
+
+// DuplicatePolicy decides what happens to emojis from different sources
+// which have the same title
+type DuplicatePolicy int
+
+const (
+	// KeepDuplicates keeps the emojis of every source
+	KeepDuplicates DuplicatePolicy = iota
+	// PreferSource keeps only the emoji from the preferred source searchable
+	PreferSource
+	// GroupVariants keeps the emoji from the first provider and lists the
+	// others as its variants
+	GroupVariants
+)
+
+type duplicateHandling struct {
+	policy    DuplicatePolicy
+	preferred string
+}
+
+// namespacedID is the id used for an emoji in the store and index
+func namespacedID(prefix, id string) string {
+	return prefix + ":" + id
+}
+
+// fetchCatalog merges the catalogs of the providers, emojis of providers
+// whose catalog wasn't modified or failed to fetch are taken from the
+// previous catalog. ErrNotModified is returned when no catalog changed and
+// a *PartialError along with the catalog when only some providers failed.
+func fetchCatalog(ctx context.Context, providers []Provider, dups duplicateHandling, previous map[string]*Emoji) (map[string]*Emoji, error) {
+	result := map[string]*Emoji{}
+	partial := &PartialError{}
+	modified := false
+	for _, p := range providers {
+		prefix := p.IDPrefix()
+
+		emojis, err := p.Fetch(ctx)
+		var fetchPartial *PartialError
+		if errors.As(err, &fetchPartial) && emojis != nil {
+			for what, err := range fetchPartial.Failed {
+				partial.add(what, err)
+			}
+			err = nil
+		}
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, fmt.Errorf("unable to fetch emojis from %s: %w", p.Name(), err)
+			}
+			if !errors.Is(err, ErrNotModified) {
+				partial.add(p.Name(), err)
+			}
+
+			for id, e := range previous {
+				if e.Source != prefix {
+					continue
+				}
+				// duplicates are merged again with the new catalogs
+				unmerged := *e
+				unmerged.Variants, unmerged.VariantOf = nil, ""
+				result[id] = &unmerged
+			}
+			continue
+		}
+
+		modified = true
+		for id, e := range emojis {
+			e.Source = prefix
+			result[namespacedID(prefix, id)] = e
+		}
+	}
+
+	if !modified {
+		if len(partial.Failed) > 0 {
+			return nil, partial
+		}
+		return nil, ErrNotModified
+	}
+
+	mergeDuplicates(result, providers, dups)
+	if len(partial.Failed) > 0 {
+		return result, partial
+	}
+	return result, nil
+}
+
+// mergeDuplicates applies the duplicate policy to emojis sharing a title
+// across sources
+func mergeDuplicates(store map[string]*Emoji, providers []Provider, dups duplicateHandling) {
+	if dups.policy == KeepDuplicates {
+		return
+	}
+
+	sourceOrder := map[string]int{}
+	for i, p := range providers {
+		if _, ok := sourceOrder[p.IDPrefix()]; !ok {
+			sourceOrder[p.IDPrefix()] = i
+		}
+	}
+
+	byTitle := map[string][]string{}
+	for id, e := range store {
+		title := strings.ToLower(e.Title)
+		byTitle[title] = append(byTitle[title], id)
+	}
+
+	for _, ids := range byTitle {
+		// ordered by provider, ids keep the order stable within a source
+		sort.Slice(ids, func(i, j int) bool {
+			si, sj := sourceOrder[store[ids[i]].Source], sourceOrder[store[ids[j]].Source]
+			if si != sj {
+				return si < sj
+			}
+			return ids[i] < ids[j]
+		})
+
+		if store[ids[0]].Source == store[ids[len(ids)-1]].Source {
+			// only duplicates across sources are merged
+			continue
+		}
+
+		switch dups.policy {
+		case PreferSource:
+			preferred := ""
+			for _, id := range ids {
+				if store[id].Source == dups.preferred {
+					preferred = id
+					break
+				}
+			}
+			if preferred == "" {
+				continue
+			}
+			// the others are kept hidden so they can be merged again
+			// when only some catalogs are fetched
+			for _, id := range ids {
+				if store[id].Source != dups.preferred {
+					store[id].VariantOf = preferred
+				}
+			}
+
+		case GroupVariants:
+			main := store[ids[0]]
+			for _, id := range ids[1:] {
+				if store[id].Source == main.Source {
+					continue
+				}
+				store[id].VariantOf = ids[0]
+				main.Variants = append(main.Variants, id)
+			}
+		}
+	}
+}
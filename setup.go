@@ -0,0 +1,132 @@
+package emos
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// providerSetup records how a provider was configured, the cache keeps the
+// setup of its providers so a later update can use the same sources
+type providerSetup struct {
+	Type            string
+	Path            string   `json:",omitempty"`
+	BaseURL         string   `json:",omitempty"`
+	Prefix          string   `json:",omitempty"`
+	AnnotationFiles []string `json:",omitempty"`
+}
+
+// setupOf describes the provider, false for providers which can't be
+// recreated, e.g. ones implemented outside of this package
+func setupOf(p Provider) (providerSetup, bool) {
+	switch p := p.(type) {
+	case *EmojiGG:
+		return providerSetup{Type: "emojigg", BaseURL: p.BaseURL}, true
+	case *Unicode:
+		annotations := make([]string, len(p.AnnotationFiles))
+		for i, fn := range p.AnnotationFiles {
+			annotations[i] = absPath(fn)
+		}
+		return providerSetup{Type: "unicode", Path: absPath(p.EmojiTestFile), AnnotationFiles: annotations}, true
+	case *Directory:
+		return providerSetup{Type: "directory", Path: absPath(p.Path), BaseURL: p.BaseURL, Prefix: p.Prefix}, true
+	case *SlackExport:
+		return providerSetup{Type: "slack", Path: absPath(p.Path), Prefix: p.Prefix}, true
+	case *DiscordGuild:
+		return providerSetup{Type: "discord", Path: absPath(p.Path), Prefix: p.Prefix}, true
+	}
+	return providerSetup{}, false
+}
+
+// absPath keeps paths working for updates started in another directory
+func absPath(fn string) string {
+	if fn == "" {
+		return ""
+	}
+	if abs, err := filepath.Abs(fn); err == nil {
+		return abs
+	}
+	return fn
+}
+
+func (s providerSetup) provider() (Provider, error) {
+	switch s.Type {
+	case "emojigg":
+		return &EmojiGG{BaseURL: s.BaseURL}, nil
+	case "unicode":
+		return &Unicode{EmojiTestFile: s.Path, AnnotationFiles: s.AnnotationFiles}, nil
+	case "directory":
+		return &Directory{Path: s.Path, BaseURL: s.BaseURL, Prefix: s.Prefix}, nil
+	case "slack":
+		return &SlackExport{Path: s.Path, Prefix: s.Prefix}, nil
+	case "discord":
+		return &DiscordGuild{Path: s.Path, Prefix: s.Prefix}, nil
+	}
+	return nil, fmt.Errorf("unknown provider %q", s.Type)
+}
+
+// cachedSource stands in for a provider which can't be recreated from the
+// cache, its cached emojis are kept as if its catalog wasn't modified
+type cachedSource string
+
+func (s cachedSource) Name() string {
+	return fmt.Sprintf("cached %s emojis", string(s))
+}
+
+func (s cachedSource) IDPrefix() string {
+	return string(s)
+}
+
+func (s cachedSource) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	return nil, ErrNotModified
+}
+
+func providerSetups(providers []Provider) []providerSetup {
+	setups := []providerSetup{}
+	for _, p := range providers {
+		if s, ok := setupOf(p); ok {
+			setups = append(setups, s)
+		}
+	}
+	return setups
+}
+
+// useCachedSetup adds the providers the cache was written with which
+// aren't configured, and uses its compression unless one was configured.
+// It's only needed to update, searches don't read the whole cache.
+func (es *EmojiSearch) useCachedSetup() {
+	es.cachedSetup = false
+	cache, _, err := readCache(es.emojiCacheLoc)
+	if err != nil {
+		// nothing to keep, the configured providers fetch everything
+		return
+	}
+
+	configured := map[string]bool{}
+	for _, p := range es.providers {
+		configured[p.IDPrefix()] = true
+	}
+	for _, s := range cache.Providers {
+		p, err := s.provider()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "ignoring cached provider:", err)
+			continue
+		}
+		if !configured[p.IDPrefix()] {
+			es.providers = append(es.providers, p)
+			configured[p.IDPrefix()] = true
+		}
+	}
+	// caches written before the setup was recorded only know the sources
+	for _, source := range cache.Sources {
+		if !configured[source] {
+			es.providers = append(es.providers, cachedSource(source))
+			configured[source] = true
+		}
+	}
+
+	if !es.compressionSet {
+		es.compression = cache.Compression
+	}
+}
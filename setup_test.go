@@ -0,0 +1,49 @@
+package emos
+
+import (
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+)
+
+func TestCachedSetupKeepsSources(t *testing.T) {
+	srv, _ := fakeEmojiGG(t, 0, http.StatusOK)
+	dir := t.TempDir()
+	loc, indexLoc := filepath.Join(dir, "emoji.json"), filepath.Join(dir, "index")
+
+	slack := filepath.Join(dir, "slack.json")
+	if err := ioutil.WriteFile(slack, []byte(testSlackExport), writePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	es, err := NewEmojiSearch(loc, indexLoc,
+		WithProvider(newTestEmojiGG(srv.URL)),
+		WithProvider(&SlackExport{Path: slack}),
+		WithCompression(Gzip))
+	if err != nil {
+		t.Fatal(err)
+	}
+	es.Close()
+
+	// a refresh started by a search which only configured emoji.gg
+	es, err = NewEmojiSearch(loc, indexLoc, WithProvider(newTestEmojiGG(srv.URL)), WithCachedSetup())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer es.Close()
+	if err := es.UpdateEmojis(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := es.Get("slack:partyparrot"); !ok {
+		t.Fatal("expected the slack emojis to be kept")
+	}
+	c, _, err := readCache(loc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Compression != Gzip || len(c.Providers) != 2 {
+		t.Fatalf("expected the setup to be kept, got: %+v", c.cacheHeader)
+	}
+}
@@ -0,0 +1,108 @@
+package emos
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+const slackAliasPrefix = "alias:"
+
+// SlackExport provides the custom emojis of a slack workspace from a saved
+// emoji.list api response
+type SlackExport struct {
+	// Path of the emoji.list json file
+	Path string
+	// Prefix overrides the IDPrefix, needed when using several workspaces
+	Prefix string
+}
+
+// Name of the provider
+func (p *SlackExport) Name() string {
+	return fmt.Sprintf("slack export %s", p.Path)
+}
+
+// IDPrefix of slack emojis
+func (p *SlackExport) IDPrefix() string {
+	if p.Prefix != "" {
+		return p.Prefix
+	}
+	return "slack"
+}
+
+type slackEmojiList struct {
+	OK    bool              `json:"ok"`
+	Error string            `json:"error"`
+	Emoji map[string]string `json:"emoji"`
+}
+
+// Fetch reads the exported emoji list, aliases are attached to the emoji
+// they point to
+func (p *SlackExport) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	data, err := ioutil.ReadFile(p.Path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read slack export: %w", err)
+	}
+
+	var list slackEmojiList
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("unable to decode slack export: %w", err)
+	}
+	if !list.OK && list.Error != "" {
+		return nil, fmt.Errorf("slack export contains an error response: %s", list.Error)
+	}
+
+	result := map[string]*Emoji{}
+	for name, link := range list.Emoji {
+		if strings.HasPrefix(link, slackAliasPrefix) {
+			continue
+		}
+		result[name] = &Emoji{
+			Title: name,
+			Image: link,
+		}
+	}
+
+	// sorted to keep the order of aliases stable between imports
+	names := make([]string, 0, len(list.Emoji))
+	for name := range list.Emoji {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		target, ok := resolveSlackAlias(list.Emoji, name)
+		if !ok || target == name {
+			// aliases of standard emojis have no custom image to attach to
+			continue
+		}
+		if e, ok := result[target]; ok {
+			e.Aliases = append(e.Aliases, name)
+		}
+	}
+
+	return result, nil
+}
+
+// resolveSlackAlias follows alias chains to the name of the actual emoji
+func resolveSlackAlias(emojis map[string]string, name string) (string, bool) {
+	seen := map[string]struct{}{}
+	for {
+		link, ok := emojis[name]
+		if !ok {
+			return "", false
+		}
+		if !strings.HasPrefix(link, slackAliasPrefix) {
+			return name, true
+		}
+
+		if _, ok := seen[name]; ok {
+			return "", false
+		}
+		seen[name] = struct{}{}
+		name = strings.TrimPrefix(link, slackAliasPrefix)
+	}
+}
@@ -0,0 +1,71 @@
+package emos
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testSlackExport = `{"ok":true,"emoji":{
+	"partyparrot":"https://emoji.slack-edge.com/T1/partyparrot/1.gif",
+	"pp":"alias:partyparrot",
+	"ppp":"alias:pp",
+	"loop1":"alias:loop2",
+	"loop2":"alias:loop1",
+	"yes":"alias:+1"
+}}`
+
+func TestSlackExportAliases(t *testing.T) {
+	fn := filepath.Join(t.TempDir(), "emoji.json")
+	if err := ioutil.WriteFile(fn, []byte(testSlackExport), writePerms); err != nil {
+		t.Fatal(err)
+	}
+
+	emojis, err := (&SlackExport{Path: fn}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(emojis) != 1 {
+		t.Fatalf("expected only the emoji with an image, got: %v", emojis)
+	}
+	e := emojis["partyparrot"]
+	if e == nil || !reflect.DeepEqual(e.Aliases, []string{"pp", "ppp"}) {
+		t.Fatalf("expected the alias chain on partyparrot, got: %+v", e)
+	}
+}
+
+func TestResolveSlackAlias(t *testing.T) {
+	emojis := map[string]string{
+		"partyparrot": "https://emoji.slack-edge.com/T1/partyparrot/1.gif",
+		"pp":          "alias:partyparrot",
+		"ppp":         "alias:pp",
+		"loop1":       "alias:loop2",
+		"loop2":       "alias:loop1",
+		"self":        "alias:self",
+		"yes":         "alias:+1",
+	}
+
+	tests := []struct {
+		name   string
+		target string
+		ok     bool
+	}{
+		{"partyparrot", "partyparrot", true},
+		{"pp", "partyparrot", true},
+		{"ppp", "partyparrot", true},
+		{"loop1", "", false},
+		{"self", "", false},
+		// standard emojis aren't in the export
+		{"yes", "", false},
+		{"missing", "", false},
+	}
+	for _, test := range tests {
+		target, ok := resolveSlackAlias(emojis, test.name)
+		if target != test.target || ok != test.ok {
+			t.Fatalf("%s resolved to %q, %v, expected %q, %v", test.name, target, ok, test.target, test.ok)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package emos
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// streamingProvider returns the provider when the catalog can be indexed
+// while it downloads. With several providers the duplicates can only be
+// merged once every catalog is complete.
+func streamingProvider(providers []Provider) (StreamingProvider, bool) {
+	if len(providers) != 1 {
+		return nil, false
+	}
+	p, ok := providers[0].(StreamingProvider)
+	return p, ok
+}
+
+// streamCatalog fetches the catalog of p and indexes the emojis which
+// changed as they arrive, emojis no longer in the catalog are removed once
+// it is complete. When the catalog can't be read the index is restored to
+// the current store. Until the index is complete it is marked as matching
+// no cache, so an interrupted update causes it to be rebuilt.
+func (es *EmojiSearch) streamCatalog(ctx context.Context, p StreamingProvider, generation int64) (map[string]*Emoji, error) {
+	old := es.store
+	if es.IsIndexEmpty() {
+		// every emoji has to be indexed
+		old = nil
+	}
+
+	w, err := es.index.Writer()
+	if err != nil {
+		return nil, err
+	}
+	// written on its own, a batch only keeps one update per document
+	if err := w.SetGeneration(-1); err == nil {
+		err = w.Flush()
+	}
+	if err != nil {
+		w.Close()
+		return nil, err
+	}
+
+	prefix := p.IDPrefix()
+	store := map[string]*Emoji{}
+	var touched []string
+
+	err = p.Stream(ctx, func(id string, e *Emoji) error {
+		e.Source = prefix
+		id = namespacedID(prefix, id)
+		store[id] = e
+
+		if prev, ok := old[id]; ok && reflect.DeepEqual(prev, e) {
+			return nil
+		}
+		touched = append(touched, id)
+		return w.Update(id, e)
+	})
+
+	var partial *PartialError
+	if err != nil && !errors.As(err, &partial) {
+		for _, id := range touched {
+			if prev, ok := old[id]; ok {
+				w.Update(id, prev)
+			} else {
+				w.Delete(id)
+			}
+		}
+		w.SetGeneration(es.generation)
+		w.Close()
+		return nil, err
+	}
+
+	for id := range old {
+		if _, ok := store[id]; ok {
+			continue
+		}
+		if err := w.Delete(id); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+	if err := w.SetGeneration(generation); err != nil {
+		w.Close()
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	// partial failures are passed on with the emojis which were fetched
+	return store, err
+}
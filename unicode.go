@@ -0,0 +1,183 @@
+package emos
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed data/emoji-test.txt
+var bundledEmojiTest string
+
+//go:embed data/annotations-en.xml
+var bundledAnnotations string
+
+const variationSelector = "\uFE0F"
+
+// Unicode provides the standard Unicode emojis. Names and groups come from
+// emoji-test.txt and keywords from the CLDR annotations, bundled copies of
+// both are used unless other files are given.
+type Unicode struct {
+	// EmojiTestFile is an optional path to a newer emoji-test.txt
+	EmojiTestFile string
+	// AnnotationFiles are optional paths to CLDR annotation files, e.g. the
+	// full common/annotations/en.xml, merged over the bundled annotations
+	AnnotationFiles []string
+}
+
+// Name of the provider
+func (p *Unicode) Name() string {
+	return "Unicode"
+}
+
+// IDPrefix of unicode emojis
+func (p *Unicode) IDPrefix() string {
+	return "unicode"
+}
+
+// Fetch parses the emoji test data and annotations
+func (p *Unicode) Fetch(ctx context.Context) (map[string]*Emoji, error) {
+	annotations, err := parseAnnotations(strings.NewReader(bundledAnnotations))
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse bundled annotations: %w", err)
+	}
+
+	for _, fn := range p.AnnotationFiles {
+		extra, err := readAnnotations(fn)
+		if err != nil {
+			return nil, err
+		}
+		for ch, keywords := range extra {
+			annotations[ch] = keywords
+		}
+	}
+
+	var src io.Reader = strings.NewReader(bundledEmojiTest)
+	if p.EmojiTestFile != "" {
+		f, err := os.Open(p.EmojiTestFile)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open emoji test data: %w", err)
+		}
+		defer f.Close()
+		src = f
+	}
+
+	emojis, err := parseEmojiTest(src)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse emoji test data: %w", err)
+	}
+
+	for _, e := range emojis {
+		if keywords, ok := annotations[e.Character]; ok {
+			e.Keywords = keywords
+		} else if keywords, ok := annotations[strings.ReplaceAll(e.Character, variationSelector, "")]; ok {
+			e.Keywords = keywords
+		}
+	}
+
+	return emojis, nil
+}
+
+// parseEmojiTest reads the fully-qualified emojis from the emoji-test.txt format:
+//
+//	1F622 ; fully-qualified # 😢 E0.6 crying face
+func parseEmojiTest(r io.Reader) (map[string]*Emoji, error) {
+	result := map[string]*Emoji{}
+	group := ""
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "# group:") {
+			group = strings.TrimSpace(strings.TrimPrefix(line, "# group:"))
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ";", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("malformed line: %q", line)
+		}
+		codepoints := strings.Fields(parts[0])
+
+		statusAndName := strings.SplitN(parts[1], "#", 2)
+		if len(statusAndName) != 2 {
+			return nil, fmt.Errorf("malformed line: %q", line)
+		}
+		if strings.TrimSpace(statusAndName[0]) != "fully-qualified" {
+			continue
+		}
+
+		// the comment holds the character, the emoji version and the name
+		fields := strings.Fields(statusAndName[1])
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("malformed line: %q", line)
+		}
+
+		result["U+"+strings.Join(codepoints, "-")] = &Emoji{
+			Title:     strings.Join(fields[2:], " "),
+			Character: fields[0],
+			Category:  group,
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+type cldrAnnotations struct {
+	Annotations []struct {
+		CP   string `xml:"cp,attr"`
+		Type string `xml:"type,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"annotations>annotation"`
+}
+
+// parseAnnotations maps characters to their CLDR keywords
+func parseAnnotations(r io.Reader) (map[string][]string, error) {
+	var doc cldrAnnotations
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, err
+	}
+
+	result := map[string][]string{}
+	for _, a := range doc.Annotations {
+		// tts entries only repeat the name which emoji-test.txt already has
+		if a.Type == "tts" {
+			continue
+		}
+
+		keywords := []string{}
+		for _, k := range strings.Split(a.Text, "|") {
+			if k = strings.TrimSpace(k); k != "" {
+				keywords = append(keywords, k)
+			}
+		}
+		result[a.CP] = keywords
+	}
+	return result, nil
+}
+
+func readAnnotations(fn string) (map[string][]string, error) {
+	f, err := os.Open(fn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open annotations: %w", err)
+	}
+	defer f.Close()
+
+	annotations, err := parseAnnotations(f)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse annotations %s: %w", fn, err)
+	}
+	return annotations, nil
+}
@@ -0,0 +1,47 @@
+package emos
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestParseEmojiTest(t *testing.T) {
+	data := `# group: Smileys & Emotion
+
+# subgroup: face-concerned
+1F622 ; fully-qualified # 😢 E0.6 crying face
+# subgroup: heart
+2764 FE0F ; fully-qualified # ❤️ E0.6 red heart
+2764 ; unqualified # ❤ E0.6 red heart
+`
+	emojis, err := parseEmojiTest(strings.NewReader(data))
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	if len(emojis) != 2 {
+		t.Fatalf("expected 2 emojis but got %d: %v", len(emojis), emojis)
+	}
+
+	crying, ok := emojis["U+1F622"]
+	if !ok {
+		t.Fatal("expected crying face to be parsed")
+	}
+	if crying.Title != "crying face" || crying.Character != "😢" || crying.Category != "Smileys & Emotion" {
+		t.Fatalf("unexpected emoji: %+v", crying)
+	}
+}
+
+func TestUnicodeKeywords(t *testing.T) {
+	emojis, err := (&Unicode{}).Fetch(context.Background())
+	if err != nil {
+		t.Fatal("unexpected error:", err)
+	}
+
+	// annotations omit the variation selector present in emoji-test.txt
+	heart := emojis["U+2764-FE0F"]
+	if heart == nil || len(heart.Keywords) == 0 {
+		t.Fatalf("expected red heart to have keywords: %+v", heart)
+	}
+}
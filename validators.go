@@ -0,0 +1,56 @@
+package emos
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// validatorsLoc is where the validators of the catalog at cacheLoc are
+// kept, e.g. emoji.validators.json next to emoji.json
+func validatorsLoc(cacheLoc string) string {
+	return strings.TrimSuffix(cacheLoc, filepath.Ext(cacheLoc)) + ".validators.json"
+}
+
+// loadValidators sets the saved validators on the providers which support
+// conditional fetching
+func loadValidators(loc string, providers []Provider) error {
+	data, err := ioutil.ReadFile(loc)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("unable to read validators: %w", err)
+	}
+
+	saved := map[string]map[string]Validators{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return fmt.Errorf("unable to decode validators: %w", err)
+	}
+
+	for _, p := range providers {
+		if cp, ok := p.(ConditionalProvider); ok {
+			cp.SetValidators(saved[p.IDPrefix()])
+		}
+	}
+	return nil
+}
+
+// saveValidators stores the validators of the last fetch of the providers
+func saveValidators(loc string, providers []Provider) error {
+	saved := map[string]map[string]Validators{}
+	for _, p := range providers {
+		if cp, ok := p.(ConditionalProvider); ok {
+			saved[p.IDPrefix()] = cp.Validators()
+		}
+	}
+
+	data, err := json.Marshal(saved)
+	if err != nil {
+		return fmt.Errorf("unable to encode validators: %w", err)
+	}
+	return ioutil.WriteFile(loc, data, writePerms)
+}